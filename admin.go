@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"template/internal/metrics"
+)
+
+var startedAt = time.Now()
+
+// AdminRoutes returns the handler for the admin listener. It is bound to
+// a separate address so that it is never exposed with the public routes.
+func AdminRoutes(m *metrics.Metrics, level *slog.LevelVar, logger *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", m.Handler())
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("GET /buildinfo", buildInfo)
+	mux.HandleFunc("GET /runtime", runtimeStats)
+	mux.HandleFunc("GET /loglevel", getLogLevel(level))
+	mux.HandleFunc("PUT /loglevel", setLogLevel(level, logger))
+	return mux
+}
+
+func buildInfo(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		http.Error(w, "build info unavailable", http.StatusNotFound)
+		return
+	}
+
+	settings := make(map[string]string, len(info.Settings))
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	writeJSON(w, map[string]any{
+		"go_version": info.GoVersion,
+		"path":       info.Path,
+		"version":    info.Main.Version,
+		"settings":   settings,
+	})
+}
+
+func runtimeStats(w http.ResponseWriter, r *http.Request) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	writeJSON(w, map[string]any{
+		"uptime":     time.Since(startedAt).Round(time.Second).String(),
+		"goroutines": runtime.NumGoroutine(),
+		"num_cpu":    runtime.NumCPU(),
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"memory": map[string]uint64{
+			"alloc":          ms.Alloc,
+			"total_alloc":    ms.TotalAlloc,
+			"sys":            ms.Sys,
+			"heap_alloc":     ms.HeapAlloc,
+			"heap_inuse":     ms.HeapInuse,
+			"heap_objects":   ms.HeapObjects,
+			"stack_inuse":    ms.StackInuse,
+			"num_gc":         uint64(ms.NumGC),
+			"pause_total_ns": ms.PauseTotalNs,
+		},
+	})
+}
+
+func getLogLevel(level *slog.LevelVar) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"level": level.Level().String()})
+	}
+}
+
+// setLogLevel changes the log level from a plain-text body such as "debug".
+func setLogLevel(level *slog.LevelVar, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+		if err != nil {
+			http.Error(w, "unreadable body", http.StatusBadRequest)
+			return
+		}
+
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(strings.TrimSpace(string(body)))); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		level.Set(l)
+
+		logger.InfoContext(r.Context(), "log level changed", slog.String("level", l.String()))
+		writeJSON(w, map[string]string{"level": l.String()})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+const auditUsage = `usage: audit <command> [flags]
+
+commands:
+  purge  delete the audit events older than AUDIT_RETENTION`
+
+// runAudit implements the audit subcommands.
+func runAudit(args []string) int {
+	if len(args) == 0 || args[0] != "purge" {
+		fmt.Fprintln(os.Stderr, auditUsage)
+		return 2
+	}
+
+	cfg, rest, code := loadConfig(flag.NewFlagSet("audit purge", flag.ContinueOnError), args[1:])
+	if cfg == nil {
+		return code
+	}
+	if len(rest) > 0 {
+		fmt.Fprintln(os.Stderr, auditUsage)
+		return 2
+	}
+	retention := cfg.Audit.Retention
+	if retention == 0 {
+		fmt.Println("AUDIT_RETENTION is 0: audit events are kept forever")
+		return 0
+	}
+
+	err := withDB(func(ctx context.Context, conn *sql.DB) error {
+		n, err := cliAudit(conn).Purge(ctx, retention)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("purged %d audit events older than %s\n", n, time.Now().Add(-retention).Format(time.DateOnly))
+		return nil
+	})(context.Background(), cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"template/config"
+)
+
+const configUsage = `usage: config <command> [flags]
+
+commands:
+  check  validate the configuration and exit non-zero if it is invalid
+  print  print the effective configuration with secrets redacted`
+
+// runConfig implements the config subcommands. They load the
+// configuration exactly as serve would, so flags override the same way.
+func runConfig(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+	cmd, args := args[0], args[1:]
+	if cmd != "check" && cmd != "print" {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+
+	cfg, rest, err := config.Load(flag.NewFlagSet("config "+cmd, flag.ContinueOnError), args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if len(rest) > 0 {
+		fmt.Fprintf(os.Stderr, "config %s takes no arguments\n", cmd)
+		return 2
+	}
+
+	if cmd == "print" {
+		if err := cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return 1
+	}
+	if cmd == "check" {
+		fmt.Println("configuration is valid")
+	}
+	return 0
+}
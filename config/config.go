@@ -1,70 +1,320 @@
 package config
 
 import (
-	"fmt"
+	"errors"
+	"flag"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
-	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
 	Env       string
+	Host      string
 	Port      string
+	AdminAddr string
+	LogLevel  string
 	Debug     bool
 	Database  *Database
 	SecretKey string
+	Tracing   *Tracing
+	CORS      *CORS
+	Security  *Security
+	Server    *Server
+	TLS       *TLS
+	Audit     *Audit
+	Accounts  *Accounts
+	Storage   *Storage
+	Google    *Google
+
+	settings []setting
+}
+
+type TLS struct {
+	CertFile string
+	KeyFile  string
+	// SelfSigned generates a localhost certificate cached in CacheDir,
+	// for local development.
+	SelfSigned bool
+	CacheDir   string
+	// RedirectAddr, when set, runs a plain HTTP listener that redirects
+	// every request to HTTPS.
+	RedirectAddr string
+}
+
+// Enabled reports whether the main listener serves HTTPS.
+func (t *TLS) Enabled() bool {
+	return t.SelfSigned || (t.CertFile != "" && t.KeyFile != "")
+}
+
+type Audit struct {
+	// Retention is how long audit events are kept before the server's
+	// maintenance or "audit purge" deletes them. Zero keeps them forever.
+	Retention time.Duration
+}
+
+type Accounts struct {
+	// DeletionGrace is how long an account whose owner deleted it can
+	// still be restored before the server's maintenance or "user
+	// purge-deleted" removes it.
+	DeletionGrace time.Duration
+	// MaxAvatarBytes caps the size of an avatar upload.
+	MaxAvatarBytes int64
+}
+
+type Google struct {
+	// ClientID and ClientSecret identify the app to Google. Without a
+	// client ID, signing in with Google is disabled.
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback registered with Google, the app's
+	// /auth/google/callback.
+	RedirectURL string
+}
+
+type Storage struct {
+	// Dir is where uploaded files, such as avatars, are kept.
+	Dir string
+}
+
+type Server struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	HandlerTimeout    time.Duration
+	// ShutdownDelay is how long readiness reports unhealthy before the
+	// listeners stop, giving load balancers time to drain the instance.
+	ShutdownDelay time.Duration
+	// DrainTimeout bounds the whole shutdown, counted from the signal.
+	DrainTimeout time.Duration
+	// MaintenanceInterval is how often the server purges expired data,
+	// such as sessions. Zero leaves it to the CLI.
+	MaintenanceInterval time.Duration
+	MaxHeaderBytes      int
+	MaxBodyBytes        int64
+}
+
+type Security struct {
+	CSP               string
+	CSPReportOnly     bool
+	PermissionsPolicy string
+	COOP              string
+	COEP              string
+}
+
+type CORS struct {
+	AllowedOrigins   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int
+}
+
+type Tracing struct {
+	ServiceName string
+	Exporter    string
+	File        string
 }
 type Database struct {
+	// URL, when set, is used instead of the individual connection fields.
+	URL      string
 	HOST     string
 	USER     string
 	DATABASE string
 	PASSWORD string
 	PORT     string
+
+	SSLMode          string
+	SSLRootCert      string
+	StatementTimeout time.Duration
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// ConnectTimeout bounds the retries while waiting for the database at startup.
+	ConnectTimeout time.Duration
+	// AutoMigrate applies pending migrations when the server starts.
+	AutoMigrate bool
 }
 
-var (
-	cfg  *Config
-	once sync.Once
-)
+// Load resolves the configuration from, in increasing priority, the YAML
+// or TOML file named by -config or CONFIG_FILE, the environment (including
+// .env and KEY_FILE secrets), and command-line flags such as -db-host.
+// The result is not validated; call Validate before using it.
+//
+// The configuration flags are added to fs, which may already hold a
+// command's own flags; a nil fs gets a fresh set. Flags and positional
+// arguments may be interleaved, and the positional ones are returned.
+func Load(fs *flag.FlagSet, args []string) (*Config, []string, error) {
+	_ = godotenv.Load()
+
+	// A first pass discovers every setting so that each gets a flag.
+	discovery := &loader{}
+	build(discovery)
 
-func Load() *Config {
-	once.Do(func() {
-		_ = godotenv.Load()
-
-		cfg = &Config{
-			Env:       getEnv("APP_ENV", "development"),
-			Port:      getEnv("PORT", "8080"),
-			Debug:     getEnvAsBool("DEBUG", true),
-			SecretKey: getEnv("SECRET_KEY", "changeme"),
-			Database: &Database{
-				HOST:     getEnv("DB_HOST", "localhost"),
-				USER:     getEnv("DB_USER", "user"),
-				DATABASE: getEnv("DB_NAME", "dbname"),
-				PASSWORD: getEnv("DB_PASSWORD", "dbpassword"),
-				PORT:     getEnv("DB_PORT", "5432"),
-			},
+	if fs == nil {
+		fs = flag.NewFlagSet("config", flag.ContinueOnError)
+	}
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	keys := make(map[string]string, len(discovery.settings))
+	for _, s := range discovery.settings {
+		keys[flagName(s.key)] = s.key
+		fs.String(flagName(s.key), "", "overrides "+s.key)
+	}
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		// Everything after "--" is positional.
+		if n := len(args) - fs.NArg(); n > 0 && args[n-1] == "--" {
+			positional = append(positional, fs.Args()...)
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	l := &loader{flags: make(map[string]string)}
+	fs.Visit(func(f *flag.Flag) {
+		if key, ok := keys[f.Name]; ok {
+			l.flags[key] = f.Value.String()
 		}
 	})
-	return cfg
-}
+	if *configFile != "" {
+		file, err := readConfigFile(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		l.file = file
+	}
 
-func getEnv(key, fallback string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
+	cfg := build(l)
+	cfg.settings = l.settings
+	if err := errors.Join(l.errs...); err != nil {
+		return nil, nil, err
 	}
-	return fallback
+	return cfg, positional, nil
 }
 
-func getEnvAsBool(name string, defaultVal bool) bool {
-	valStr := os.Getenv(name)
-	if val, err := strconv.ParseBool(valStr); err == nil {
-		return val
+func build(l *loader) *Config {
+	return &Config{
+		Env:       l.get("APP_ENV", "development"),
+		Host:      l.get("HOST", ""),
+		Port:      l.get("PORT", "8080"),
+		AdminAddr: l.get("ADMIN_ADDR", "127.0.0.1:9090"),
+		LogLevel:  l.get("LOG_LEVEL", "info"),
+		Debug:     l.getBool("DEBUG", true),
+		SecretKey: l.getSecret("SECRET_KEY", "changeme"),
+		Database: &Database{
+			URL:              l.getSecret("DATABASE_URL", ""),
+			HOST:             l.get("DB_HOST", "localhost"),
+			USER:             l.get("DB_USER", "user"),
+			DATABASE:         l.get("DB_NAME", "dbname"),
+			PASSWORD:         l.getSecret("DB_PASSWORD", "dbpassword"),
+			PORT:             l.get("DB_PORT", "5432"),
+			SSLMode:          l.get("DB_SSLMODE", "disable"),
+			SSLRootCert:      l.get("DB_SSLROOTCERT", ""),
+			StatementTimeout: l.getDuration("DB_STATEMENT_TIMEOUT", 30*time.Second),
+			MaxOpenConns:     l.getInt("DB_MAX_OPEN_CONNS", 25),
+			MaxIdleConns:     l.getInt("DB_MAX_IDLE_CONNS", 25),
+			ConnMaxLifetime:  l.getDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+			ConnMaxIdleTime:  l.getDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+			ConnectTimeout:   l.getDuration("DB_CONNECT_TIMEOUT", 30*time.Second),
+			AutoMigrate:      l.getBool("DB_AUTO_MIGRATE", false),
+		},
+		Tracing: &Tracing{
+			ServiceName: l.get("OTEL_SERVICE_NAME", "template"),
+			Exporter:    l.get("TRACE_EXPORTER", "none"),
+			File:        l.get("TRACE_FILE", "traces.json"),
+		},
+		TLS: &TLS{
+			CertFile:     l.get("TLS_CERT_FILE", ""),
+			KeyFile:      l.get("TLS_KEY_FILE", ""),
+			SelfSigned:   l.getBool("TLS_SELF_SIGNED", false),
+			CacheDir:     l.get("TLS_CACHE_DIR", ".cache/tls"),
+			RedirectAddr: l.get("TLS_REDIRECT_ADDR", ""),
+		},
+		Server: &Server{
+			ReadHeaderTimeout:   l.getDuration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+			ReadTimeout:         l.getDuration("SERVER_READ_TIMEOUT", 15*time.Second),
+			WriteTimeout:        l.getDuration("SERVER_WRITE_TIMEOUT", 30*time.Second),
+			IdleTimeout:         l.getDuration("SERVER_IDLE_TIMEOUT", 120*time.Second),
+			HandlerTimeout:      l.getDuration("SERVER_HANDLER_TIMEOUT", 20*time.Second),
+			ShutdownDelay:       l.getDuration("SERVER_SHUTDOWN_DELAY", 5*time.Second),
+			DrainTimeout:        l.getDuration("SERVER_DRAIN_TIMEOUT", 30*time.Second),
+			MaintenanceInterval: l.getDuration("SERVER_MAINTENANCE_INTERVAL", time.Hour),
+			MaxHeaderBytes:      l.getInt("SERVER_MAX_HEADER_BYTES", 1<<20),
+			MaxBodyBytes:        int64(l.getInt("SERVER_MAX_BODY_BYTES", 1<<20)),
+		},
+		Security: &Security{
+			CSP:               l.get("CSP_POLICY", ""),
+			CSPReportOnly:     l.getBool("CSP_REPORT_ONLY", false),
+			PermissionsPolicy: l.get("PERMISSIONS_POLICY", "camera=(), microphone=(), geolocation=()"),
+			COOP:              l.get("CROSS_ORIGIN_OPENER_POLICY", ""),
+			COEP:              l.get("CROSS_ORIGIN_EMBEDDER_POLICY", ""),
+		},
+		Audit: &Audit{
+			Retention: l.getDuration("AUDIT_RETENTION", 365*24*time.Hour),
+		},
+		Accounts: &Accounts{
+			DeletionGrace:  l.getDuration("ACCOUNT_DELETION_GRACE", 30*24*time.Hour),
+			MaxAvatarBytes: int64(l.getInt("AVATAR_MAX_BYTES", 5<<20)),
+		},
+		Storage: &Storage{
+			Dir: l.get("STORAGE_DIR", "data/storage"),
+		},
+		Google: &Google{
+			ClientID:     l.get("GOOGLE_CLIENT_ID", ""),
+			ClientSecret: l.getSecret("GOOGLE_CLIENT_SECRET", ""),
+			RedirectURL:  l.get("GOOGLE_REDIRECT_URL", ""),
+		},
+		CORS: &CORS{
+			AllowedOrigins:   l.getSlice("CORS_ALLOWED_ORIGINS", nil),
+			AllowedHeaders:   l.getSlice("CORS_ALLOWED_HEADERS", []string{"Content-Type", "X-CSRF-Token"}),
+			AllowCredentials: l.getBool("CORS_ALLOW_CREDENTIALS", true),
+			MaxAge:           l.getInt("CORS_MAX_AGE", 600),
+		},
 	}
-	return defaultVal
 }
 
+// Addr is the address the main listener binds to.
+func (c *Config) Addr() string {
+	return net.JoinHostPort(c.Host, c.Port)
+}
+
+// String returns the connection URL. Parameters already present in URL
+// take precedence over SSLMode, SSLRootCert and StatementTimeout.
 func (d *Database) String() string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", d.USER, d.PASSWORD, d.HOST, d.PORT, d.DATABASE)
+	u, err := url.Parse(d.URL)
+	if d.URL == "" || err != nil {
+		u = &url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(d.USER, d.PASSWORD),
+			Host:   net.JoinHostPort(d.HOST, d.PORT),
+			Path:   "/" + d.DATABASE,
+		}
+	}
+
+	q := u.Query()
+	setDefault := func(key, value string) {
+		if value != "" && !q.Has(key) {
+			q.Set(key, value)
+		}
+	}
+	setDefault("sslmode", d.SSLMode)
+	setDefault("sslrootcert", d.SSLRootCert)
+	if d.StatementTimeout > 0 {
+		setDefault("statement_timeout", strconv.FormatInt(d.StatementTimeout.Milliseconds(), 10))
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
 }
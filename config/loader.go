@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// setting is one resolved configuration value, kept for Print.
+type setting struct {
+	key    string
+	value  string
+	source string
+	secret bool
+}
+
+// loader resolves each setting from, in decreasing priority: command-line
+// flags, the environment (KEY, then the file named by KEY_FILE), the
+// config file, and finally the default. Parse errors are collected rather
+// than silently replaced by the default.
+type loader struct {
+	flags    map[string]string
+	file     map[string]string
+	settings []setting
+	errs     []error
+}
+
+func (l *loader) lookup(key string) (value, source string, ok bool) {
+	if v, ok := l.flags[key]; ok {
+		return v, "flag", true
+	}
+	if v := os.Getenv(key); v != "" {
+		return v, "env", true
+	}
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s_FILE: %w", key, err))
+			return "", "", false
+		}
+		return strings.TrimRight(string(b), "\r\n"), "env file", true
+	}
+	if v, ok := l.file[key]; ok {
+		return v, "config file", true
+	}
+	return "", "", false
+}
+
+func (l *loader) resolve(key, fallback string, secret bool) string {
+	value, source, ok := l.lookup(key)
+	if !ok {
+		value, source = fallback, "default"
+	}
+	l.settings = append(l.settings, setting{key: key, value: value, source: source, secret: secret})
+	return value
+}
+
+func (l *loader) get(key, fallback string) string {
+	return l.resolve(key, fallback, false)
+}
+
+// getSecret is get for values that Print must redact.
+func (l *loader) getSecret(key, fallback string) string {
+	return l.resolve(key, fallback, true)
+}
+
+func (l *loader) getBool(key string, fallback bool) bool {
+	v := l.resolve(key, strconv.FormatBool(fallback), false)
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: invalid boolean %q", key, v))
+		return fallback
+	}
+	return b
+}
+
+func (l *loader) getInt(key string, fallback int) int {
+	v := l.resolve(key, strconv.Itoa(fallback), false)
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: invalid integer %q", key, v))
+		return fallback
+	}
+	return n
+}
+
+// getDuration parses values such as "5s" or "2m".
+func (l *loader) getDuration(key string, fallback time.Duration) time.Duration {
+	v := l.resolve(key, fallback.String(), false)
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: invalid duration %q", key, v))
+		return fallback
+	}
+	return d
+}
+
+// getSlice splits a comma-separated value, dropping empty entries.
+func (l *loader) getSlice(key string, fallback []string) []string {
+	v := l.resolve(key, strings.Join(fallback, ","), false)
+	var vals []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			vals = append(vals, s)
+		}
+	}
+	return vals
+}
+
+// readConfigFile loads a YAML or TOML file, chosen by extension, into
+// setting keys: nested tables are joined with underscores and upper-cased,
+// so db: {host: x} sets DB_HOST, and lists become comma-separated.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	raw := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format, want .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file: %w", err)
+	}
+
+	out := make(map[string]string)
+	flatten(out, "", raw)
+	return out, nil
+}
+
+func flatten(out map[string]string, prefix string, v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			key := strings.ToUpper(k)
+			if prefix != "" {
+				key = prefix + "_" + key
+			}
+			flatten(out, key, child)
+		}
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		out[prefix] = strings.Join(items, ",")
+	default:
+		out[prefix] = fmt.Sprint(v)
+	}
+}
+
+// flagName turns a setting key such as DB_HOST into the flag name db-host.
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
@@ -1,16 +1,56 @@
 package config
 
 import (
+	"context"
 	"log/slog"
 	"os"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
-func NewSlog(env string) *slog.Logger {
+// NewSlog builds the application logger. Its minimum level is read from
+// level on every record, so it can be changed at runtime.
+func NewSlog(env string, level *slog.LevelVar) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+
 	var handler slog.Handler
 	if env == "production" {
-		handler = slog.NewJSONHandler(os.Stdout, nil)
+		handler = slog.NewJSONHandler(os.Stdout, opts)
 	} else {
-		handler = slog.NewTextHandler(os.Stdout, nil)
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	}
+	return slog.New(traceHandler{handler})
+}
+
+// NewLevel parses a level name such as "debug" or "warn", falling back to info.
+func NewLevel(name string) *slog.LevelVar {
+	level := new(slog.LevelVar)
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		level.Set(slog.LevelInfo)
+	}
+	return level
+}
+
+// traceHandler adds the trace and span IDs of the span in the record's
+// context, so log lines can be joined with their trace.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
 	}
-	return slog.New(handler)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
 }
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+)
+
+// minSecretKeyLength is the shortest SECRET_KEY accepted in production.
+const minSecretKeyLength = 32
+
+// insecureDefaults are the development fallbacks that must be overridden
+// in production.
+var insecureDefaults = map[string]string{
+	"SECRET_KEY":  "changeme",
+	"DB_PASSWORD": "dbpassword",
+}
+
+func (c *Config) IsProduction() bool {
+	return c.Env == "production"
+}
+
+// Validate checks the configuration, and refuses insecure defaults and
+// development-only settings in production.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT: invalid port %q", c.Port))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout", "file":
+	default:
+		errs = append(errs, fmt.Errorf("TRACE_EXPORTER: unknown exporter %q", c.Tracing.Exporter))
+	}
+	if c.Database.URL != "" {
+		if u, err := url.Parse(c.Database.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			errs = append(errs, errors.New("DATABASE_URL: must be a postgres:// URL"))
+		}
+	}
+	switch c.Database.SSLMode {
+	case "disable", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, fmt.Errorf("DB_SSLMODE: unsupported mode %q", c.Database.SSLMode))
+	}
+	if c.Database.MaxIdleConns > c.Database.MaxOpenConns && c.Database.MaxOpenConns > 0 {
+		errs = append(errs, errors.New("DB_MAX_IDLE_CONNS must not exceed DB_MAX_OPEN_CONNS"))
+	}
+	if c.Google.ClientID != "" {
+		if c.Google.ClientSecret == "" {
+			errs = append(errs, errors.New("GOOGLE_CLIENT_SECRET is required with GOOGLE_CLIENT_ID"))
+		}
+		if u, err := url.Parse(c.Google.RedirectURL); err != nil || !u.IsAbs() {
+			errs = append(errs, errors.New("GOOGLE_REDIRECT_URL: must be an absolute URL"))
+		}
+	}
+	if c.Server.MaintenanceInterval < 0 {
+		errs = append(errs, errors.New("SERVER_MAINTENANCE_INTERVAL must not be negative"))
+	}
+	if c.Audit.Retention < 0 {
+		errs = append(errs, errors.New("AUDIT_RETENTION must not be negative"))
+	}
+	if c.Accounts.DeletionGrace < 0 {
+		errs = append(errs, errors.New("ACCOUNT_DELETION_GRACE must not be negative"))
+	}
+	if c.Accounts.MaxAvatarBytes <= 0 {
+		errs = append(errs, errors.New("AVATAR_MAX_BYTES must be positive"))
+	}
+	if c.Storage.Dir == "" {
+		errs = append(errs, errors.New("STORAGE_DIR must not be empty"))
+	}
+	if slices.Contains(c.CORS.AllowedOrigins, "*") && c.CORS.AllowCredentials {
+		errs = append(errs, errors.New(`CORS_ALLOWED_ORIGINS: "*" cannot be combined with CORS_ALLOW_CREDENTIALS`))
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+
+	if c.IsProduction() {
+		if c.SecretKey == insecureDefaults["SECRET_KEY"] {
+			errs = append(errs, errors.New("SECRET_KEY: default value is not allowed in production"))
+		} else if len(c.SecretKey) < minSecretKeyLength {
+			errs = append(errs, fmt.Errorf("SECRET_KEY: must be at least %d characters in production", minSecretKeyLength))
+		}
+		// The DB_* connection fields are ignored when DATABASE_URL is set.
+		if c.Database.URL == "" && c.Database.PASSWORD == insecureDefaults["DB_PASSWORD"] {
+			errs = append(errs, errors.New("DB_PASSWORD: default value is not allowed in production"))
+		}
+		if c.TLS.SelfSigned {
+			errs = append(errs, errors.New("TLS_SELF_SIGNED: self-signed certificates are for development only"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Print writes every setting with its effective value and where it came
+// from. Secrets are redacted.
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, s := range c.settings {
+		value := s.value
+		if s.secret && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t(%s)\n", s.key, value, s.source)
+	}
+	return tw.Flush()
+}
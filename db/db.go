@@ -1,39 +1,90 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	"embed"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/rand/v2"
+	"time"
 
-	_ "github.com/lib/pq"
-	"github.com/pressly/goose/v3"
+	"github.com/lib/pq"
 )
 
-//go:embed migrations/*.sql
-var migrationFS embed.FS
+// Options tunes the connection pool and the startup retry.
+type Options struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// ConnectTimeout is how long NewDB keeps retrying the first ping,
+	// e.g. while Postgres is still starting.
+	ConnectTimeout time.Duration
+	Logger         *slog.Logger
+}
 
-func NewDB(databaseURL string) (*sql.DB, error) {
+func NewDB(databaseURL string, opts Options) (*sql.DB, error) {
 	db, err := sql.Open("postgres", databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("open db: %w", err)
 	}
-	if err := db.Ping(); err != nil {
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(opts.MaxIdleConns)
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.ConnectTimeout)
+	defer cancel()
+	if err := pingWithRetry(ctx, db, opts.Logger); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("ping db: %w", err)
 	}
 	return db, nil
 }
 
-func Migrate(db *sql.DB) error {
-	goose.SetBaseFS(migrationFS)
+// pingWithRetry pings until it succeeds, waiting with exponential backoff
+// and jitter between attempts, until ctx expires or the error cannot be
+// fixed by waiting.
+func pingWithRetry(ctx context.Context, db *sql.DB, logger *slog.Logger) error {
+	const (
+		initialBackoff = 250 * time.Millisecond
+		maxBackoff     = 5 * time.Second
+	)
+
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if permanent(err) || ctx.Err() != nil {
+			return err
+		}
 
-	if err := goose.SetDialect("postgres"); err != nil {
-		return err
+		wait := backoff/2 + rand.N(backoff/2)
+		if logger != nil {
+			logger.Warn("database not ready, retrying",
+				slog.Int("attempt", attempt),
+				slog.Duration("wait", wait),
+				slog.String("error", err.Error()),
+			)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		backoff = min(backoff*2, maxBackoff)
 	}
-	log.Println("Running migrations...")
-	if err := goose.Up(db, "migrations"); err != nil {
-		return err
+}
+
+// permanent reports errors that retrying will not fix: bad credentials or
+// a missing database.
+func permanent(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
 	}
-	log.Println("Migrations applied successfully")
-	return nil
+	return pqErr.Code.Class() == "28" || pqErr.Code == "3D000"
 }
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"text/tabwriter"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// MigrationsDir is where create writes new migration files, relative to
+// the repository root.
+const MigrationsDir = "db/migrations"
+
+// newProvider returns a goose provider over the embedded migrations. The
+// provider holds a Postgres advisory lock while migrating, so replicas
+// starting together do not race.
+func newProvider(db *sql.DB) (*goose.Provider, error) {
+	fsys, err := fs.Sub(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, err
+	}
+	return goose.NewProvider(goose.DialectPostgres, db, fsys, goose.WithSessionLocker(locker))
+}
+
+// Migrate applies every pending migration.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	p, err := newProvider(db)
+	if err != nil {
+		return err
+	}
+	log.Println("Running migrations...")
+	results, err := p.Up(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		log.Printf("Applied %s in %s", r.Source.Path, r.Duration)
+	}
+	log.Println("Migrations applied successfully")
+	return nil
+}
+
+// MigrateDown rolls back the latest applied migration.
+func MigrateDown(ctx context.Context, db *sql.DB) error {
+	p, err := newProvider(db)
+	if err != nil {
+		return err
+	}
+	r, err := p.Down(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Rolled back %s", r.Source.Path)
+	return nil
+}
+
+// MigrateRedo rolls back the latest applied migration and applies it again.
+func MigrateRedo(ctx context.Context, db *sql.DB) error {
+	p, err := newProvider(db)
+	if err != nil {
+		return err
+	}
+	if _, err := p.Down(ctx); err != nil {
+		return err
+	}
+	r, err := p.UpByOne(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Reapplied %s", r.Source.Path)
+	return nil
+}
+
+// MigrationStatus writes the state of every migration to w.
+func MigrationStatus(ctx context.Context, db *sql.DB, w io.Writer) error {
+	p, err := newProvider(db)
+	if err != nil {
+		return err
+	}
+	statuses, err := p.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tMIGRATION\tSTATE\tAPPLIED AT")
+	for _, s := range statuses {
+		applied := "-"
+		if !s.AppliedAt.IsZero() {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.Source.Version, s.Source.Path, s.State, applied)
+	}
+	return tw.Flush()
+}
+
+// CreateMigration writes an empty, sequentially numbered SQL migration
+// into dir.
+func CreateMigration(dir, name string) error {
+	goose.SetSequential(true)
+	return goose.Create(nil, dir, name, "sql")
+}
+
+// MigrationCheck returns a readiness check that fails unless the database
+// is at the latest embedded migration version. The provider is built once,
+// here, rather than on every probe.
+func MigrationCheck(db *sql.DB) (func(context.Context) error, error) {
+	fsys, err := fs.Sub(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	// No lock: the check must not queue behind a running migration.
+	p, err := goose.NewProvider(goose.DialectPostgres, db, fsys)
+	if err != nil {
+		return nil, fmt.Errorf("load migrations: %w", err)
+	}
+	return func(ctx context.Context) error {
+		current, target, err := p.GetVersions(ctx)
+		if err != nil {
+			return fmt.Errorf("get migration version: %w", err)
+		}
+		if current != target {
+			return fmt.Errorf("database at version %d, want %d", current, target)
+		}
+		return nil
+	}, nil
+}
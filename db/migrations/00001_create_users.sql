@@ -13,12 +13,5 @@ CREATE TABLE IF NOT EXISTS users (
 CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
 CREATE INDEX IF NOT EXISTS idx_users_google_id ON users(google_id);
 
-CREATE TABLE token (
-	token STRING NOT NULL UNIQUE,
-	expires_at INTEGER NOT NULL,
-	user_id INTEGER NOT NULL,
-    ip_address INET,
-    user_agent TEXT,
-	FOREIGN KEY (user_id) REFERENCES user(id)
-)
-
+-- +goose Down
+DROP TABLE IF EXISTS users;
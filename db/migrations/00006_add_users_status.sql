@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(32) NOT NULL DEFAULT 'active'
+    CONSTRAINT users_status_check CHECK (status IN ('active', 'suspended'));
+
+CREATE INDEX IF NOT EXISTS idx_users_status ON users(status);
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN IF EXISTS status;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- The user columns have no foreign keys so that events outlive the users
+-- they mention.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    type VARCHAR(64) NOT NULL,
+    actor_id UUID,
+    target_user_id UUID,
+    ip_address INET,
+    user_agent TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    metadata JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_target_user_id ON audit_events(target_user_id, id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events(actor_id, id);
+
+-- +goose Down
+DROP TABLE IF EXISTS audit_events;
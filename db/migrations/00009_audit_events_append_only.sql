@@ -0,0 +1,30 @@
+-- +goose Up
+-- Events are appended and, once past the retention period, deleted; they
+-- are never changed.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION audit_events_reject_update() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_reject_update();
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+
+INSERT INTO permissions (name, description) VALUES
+    ('audit:read', 'View the audit trail of every user')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'audit:read')
+ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM permissions WHERE name = 'audit:read';
+DROP INDEX IF EXISTS idx_audit_events_created_at;
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+DROP FUNCTION IF EXISTS audit_events_reject_update();
@@ -0,0 +1,11 @@
+-- +goose Up
+-- Events used to copy their target's email into the metadata, where it
+-- outlived the deletion of the account. The target's ID is enough; only
+-- failed sign-ins for unknown emails, which have no target, keep one.
+ALTER TABLE audit_events DISABLE TRIGGER audit_events_append_only;
+UPDATE audit_events SET metadata = metadata - 'email'
+    WHERE target_user_id IS NOT NULL AND metadata->>'email' IS NOT NULL;
+ALTER TABLE audit_events ENABLE TRIGGER audit_events_append_only;
+
+-- +goose Down
+-- The removed emails cannot be restored.
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"template/internal/repository"
+	"template/internal/services"
+	"template/utils"
+)
+
+// reauthWindow is how recently users without a password must have signed
+// in to confirm a sensitive action.
+const reauthWindow = 10 * time.Minute
+
+// exportWriteTimeout replaces the server's write timeout for the export,
+// which is streamed and can outlast it.
+const exportWriteTimeout = 10 * time.Minute
+
+var errReauthRequired = errors.New("sign out and sign in again to confirm")
+
+type accountPage struct {
+	User *repository.User
+	// Profile is the profile form, the user's own or what they sent.
+	Profile services.Profile
+	// GraceDays is how long a deleted account can be restored.
+	GraceDays int
+	// MaxDisplayNameLength and MaxAvatarMB describe the limits to users.
+	MaxDisplayNameLength int
+	MaxAvatarMB          int64
+	Error                string
+}
+
+func (uh *UserHandler) accountPage(r *http.Request) accountPage {
+	user := CurrentUser(r.Context())
+	return accountPage{
+		User:                 user,
+		Profile:              services.Profile{DisplayName: user.DisplayName, Locale: user.Locale, Timezone: user.Timezone},
+		GraceDays:            int(uh.DeletionGrace.Hours() / 24),
+		MaxDisplayNameLength: services.MaxDisplayNameLength,
+		MaxAvatarMB:          max(uh.MaxAvatarBytes>>20, 1),
+	}
+}
+
+// Account is the account page of signed-in users, where they edit their
+// profile and export or delete their account.
+func (uh *UserHandler) Account(w http.ResponseWriter, r *http.Request) {
+	if err := render(w, r, "pages/account.html", uh.accountPage(r)); err != nil {
+		internal(w, err)
+	}
+}
+
+// accountError shows the account page again with msg, answering code.
+func (uh *UserHandler) accountError(w http.ResponseWriter, r *http.Request, data accountPage, code int, msg string) {
+	data.Error = msg
+	w.WriteHeader(code)
+	if err := render(w, r, "pages/account.html", data); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// UpdateProfile saves the profile form.
+func (uh *UserHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	data := uh.accountPage(r)
+	data.Profile = services.Profile{
+		DisplayName: r.FormValue("display_name"),
+		Locale:      r.FormValue("locale"),
+		Timezone:    r.FormValue("timezone"),
+	}
+	err := uh.US.UpdateProfile(r.Context(), data.User, data.Profile)
+	switch {
+	case err == nil:
+		http.Redirect(w, r, "/app/account", http.StatusSeeOther)
+	case errors.Is(err, services.ErrInvalidDisplayName), errors.Is(err, services.ErrInvalidLocale), errors.Is(err, services.ErrInvalidTimezone):
+		uh.accountError(w, r, data, http.StatusUnprocessableEntity, err.Error())
+	default:
+		internal(w, err)
+	}
+}
+
+// UploadAvatar replaces the user's avatar by the uploaded image. Its route
+// raises the body limit to MaxAvatarBytes.
+func (uh *UserHandler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	data := uh.accountPage(r)
+	f, _, err := r.FormFile("avatar")
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		uh.accountError(w, r, data, http.StatusRequestEntityTooLarge, fmt.Sprintf("avatar must be at most %d MB", data.MaxAvatarMB))
+		return
+	case err != nil:
+		uh.accountError(w, r, data, http.StatusBadRequest, "choose an image to upload")
+		return
+	}
+	defer f.Close()
+
+	err = uh.US.SetAvatar(r.Context(), data.User, f)
+	switch {
+	case err == nil:
+		http.Redirect(w, r, "/app/account", http.StatusSeeOther)
+	case errors.Is(err, services.ErrInvalidImage), errors.Is(err, services.ErrImageTooLarge):
+		uh.accountError(w, r, data, http.StatusUnprocessableEntity, err.Error())
+	default:
+		internal(w, err)
+	}
+}
+
+// RemoveAvatar removes the user's avatar.
+func (uh *UserHandler) RemoveAvatar(w http.ResponseWriter, r *http.Request) {
+	if err := uh.US.RemoveAvatar(r.Context(), CurrentUser(r.Context())); err != nil {
+		internal(w, err)
+		return
+	}
+	http.Redirect(w, r, "/app/account", http.StatusSeeOther)
+}
+
+// Avatar serves an uploaded avatar. Keys are random and never reused, so
+// the route may be cached for good.
+func (uh *UserHandler) Avatar(w http.ResponseWriter, r *http.Request) {
+	f, err := uh.US.OpenAvatar(r.Context(), r.PathValue("key"))
+	if errors.Is(err, services.ErrAvatarNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		internal(w, err)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "image/png")
+	_, _ = io.Copy(w, f)
+}
+
+// avatarURL is where the user's avatar is shown from, or "" if they have
+// none.
+func avatarURL(u *repository.User) string {
+	if u == nil {
+		return ""
+	}
+	if u.AvatarKey != "" {
+		return "/avatars/" + u.AvatarKey
+	}
+	return u.AvatarURL
+}
+
+// ExportAccount downloads everything held about the user, as one JSON
+// document or, with format=zip, as a ZIP archive of one JSON file per
+// part plus the uploaded avatar.
+func (uh *UserHandler) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		badRequest(w, "format must be json or zip")
+		return
+	}
+	export, err := uh.US.Export(r.Context(), CurrentUser(r.Context()))
+	if err != nil {
+		internal(w, err)
+		return
+	}
+
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+		log.Println(err.Error())
+	}
+	name := "account-" + export.ExportedAt.Format("20060102")
+	if format != "zip" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(export)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+	// Once the archive has started the status cannot change, so a failure
+	// aborts the response rather than finishing a zip that looks complete.
+	abort := func(err error) {
+		log.Println(err.Error())
+		panic(http.ErrAbortHandler)
+	}
+	zw := zip.NewWriter(w)
+	create := func(file string, method uint16) io.Writer {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name + "/" + file, Method: method, Modified: export.ExportedAt})
+		if err != nil {
+			abort(err)
+		}
+		return f
+	}
+	for _, part := range []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", export.Profile},
+		{"identities.json", export.Identities},
+		{"roles.json", export.Roles},
+		{"sessions.json", export.Sessions},
+		{"audit_events.json", export.AuditEvents},
+	} {
+		enc := json.NewEncoder(create(part.name, zip.Deflate))
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(part.v); err != nil {
+			abort(err)
+		}
+	}
+	// An uploaded avatar goes along as the image itself, already compressed.
+	if key := CurrentUser(r.Context()).AvatarKey; key != "" {
+		avatar, err := uh.US.OpenAvatar(r.Context(), key)
+		if err != nil {
+			abort(err)
+		}
+		defer avatar.Close()
+		if _, err := io.Copy(create("avatar.png", zip.Store), avatar); err != nil {
+			abort(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		abort(err)
+	}
+}
+
+// DeleteAccountForm asks the user to confirm the deletion of their account.
+func (uh *UserHandler) DeleteAccountForm(w http.ResponseWriter, r *http.Request) {
+	if err := render(w, r, "pages/account_delete.html", uh.accountPage(r)); err != nil {
+		internal(w, err)
+	}
+}
+
+// DeleteAccount schedules the deletion of the user's account once they
+// have confirmed who they are, and signs them out.
+func (uh *UserHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r.Context())
+	if err := uh.reauthenticate(r, user); err != nil {
+		if !errors.Is(err, services.ErrInvalidCredentials) && !errors.Is(err, errReauthRequired) {
+			internal(w, err)
+			return
+		}
+		data := uh.accountPage(r)
+		data.Error = err.Error()
+		w.WriteHeader(http.StatusUnauthorized)
+		if err := render(w, r, "pages/account_delete.html", data); err != nil {
+			internal(w, err)
+		}
+		return
+	}
+
+	if err := uh.US.RequestDeletion(r.Context(), user, uh.DeletionGrace); err != nil {
+		internal(w, err)
+		return
+	}
+	clearSessionCookie(w)
+	if err := render(w, r, "pages/account_inactive.html", user); err != nil {
+		internal(w, err)
+	}
+}
+
+// reauthenticate checks that the signed-in user has just proved who they
+// are: by the posted password or, for accounts without one, by having
+// signed in within reauthWindow.
+func (uh *UserHandler) reauthenticate(r *http.Request, user *repository.User) error {
+	if user.PasswordHash.Valid {
+		return uh.US.CheckPassword(r.Context(), user, r.FormValue("password"))
+	}
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return errReauthRequired
+	}
+	session, err := uh.SS.ValidateSession(r.Context(), cookie.Value)
+	if err != nil || time.Since(session.CreatedAt) > reauthWindow {
+		return errReauthRequired
+	}
+	return nil
+}
+
+// RestoreAccount signs in the owner of the posted credentials, cancelling
+// the deletion of their account if it is pending.
+func (uh *UserHandler) RestoreAccount(w http.ResponseWriter, r *http.Request) {
+	email, pwd := utils.CleanString(r.FormValue("email")), r.FormValue("password")
+
+	user, err := uh.US.Authenticate(r.Context(), email, pwd)
+	var inactive *services.InactiveAccountError
+	switch {
+	case err == nil:
+	case errors.As(err, &inactive) && inactive.User.Status == repository.StatusPendingDeletion:
+		user = inactive.User
+		if err := uh.US.CancelDeletion(r.Context(), user); err != nil {
+			internal(w, err)
+			return
+		}
+	case errors.Is(err, services.ErrInvalidCredentials):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case inactive != nil:
+		accountInactive(w, r, inactive.User)
+		return
+	default:
+		internal(w, err)
+		return
+	}
+
+	cookieHash, err := uh.SS.SignIn(r.Context(), user, "password", net.IP(utils.GetIPAddressBytes(r)), r.UserAgent())
+	if err != nil {
+		internal(w, err)
+		return
+	}
+	setSessionCookie(w, cookieHash)
+	http.Redirect(w, r, "/app", http.StatusSeeOther)
+}
@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"template/internal/repository"
+	"template/internal/services"
+
+	"github.com/google/uuid"
+)
+
+// userListOptions reads a user listing from the query string: q (email
+// substring), provider, verified (true or false), status, created_from and
+// created_to (dates, both inclusive), sort, order (asc or desc), limit and
+// cursor.
+func userListOptions(q url.Values) (repository.ListUsersOptions, error) {
+	opts := repository.ListUsersOptions{
+		Filter: repository.UserFilter{
+			Email:    q.Get("q"),
+			Provider: q.Get("provider"),
+			Status:   q.Get("status"),
+		},
+		Sort:  q.Get("sort"),
+		After: q.Get("cursor"),
+		Limit: services.DefaultPageSize,
+	}
+
+	if v := q.Get("verified"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid verified %q", v)
+		}
+		opts.Filter.Verified = &b
+	}
+	if v := q.Get("created_from"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid created_from %q", v)
+		}
+		opts.Filter.CreatedFrom = t
+	}
+	if v := q.Get("created_to"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid created_to %q", v)
+		}
+		opts.Filter.CreatedTo = t.AddDate(0, 0, 1)
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, fmt.Errorf("invalid order %q", q.Get("order"))
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("invalid limit %q", v)
+		}
+		opts.Limit = min(n, services.MaxPageSize)
+	}
+
+	err := opts.Validate()
+	return opts, err
+}
+
+type userJSON struct {
+	ID              string     `json:"id"`
+	Email           string     `json:"email"`
+	Providers       []string   `json:"providers"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Status          string     `json:"status"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+type userListJSON struct {
+	Users      []userJSON `json:"users"`
+	Total      int        `json:"total"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+func providers(u *repository.User) []string {
+	p := []string{}
+	if u.PasswordHash.Valid {
+		p = append(p, repository.ProviderPassword)
+	}
+	if u.GoogleID.Valid {
+		p = append(p, repository.ProviderGoogle)
+	}
+	return p
+}
+
+// ListUsersJSON serves GET /api/users.
+func (uh *UserHandler) ListUsersJSON(w http.ResponseWriter, r *http.Request) {
+	opts, err := userListOptions(r.URL.Query())
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := uh.US.List(r.Context(), opts)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, "internal")
+		return
+	}
+
+	out := userListJSON{Users: []userJSON{}, Total: page.Total, NextCursor: page.Next}
+	for _, u := range page.Users {
+		ju := userJSON{ID: u.ID, Email: u.Email, Providers: providers(u), Status: u.Status, CreatedAt: u.CreatedAt}
+		if u.EmailVerifiedAt.Valid {
+			ju.EmailVerifiedAt = &u.EmailVerifiedAt.Time
+		}
+		out.Users = append(out.Users, ju)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+type adminUsersPage struct {
+	Query url.Values
+	Page  *repository.UserPage
+	// FirstURL and NextURL keep the filters of the current page.
+	FirstURL, NextURL string
+}
+
+// AdminUsers serves the user listing of the admin pages.
+func (uh *UserHandler) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts, err := userListOptions(q)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	page, err := uh.US.List(r.Context(), opts)
+	if err != nil {
+		internal(w, err)
+		return
+	}
+
+	data := adminUsersPage{Query: q, Page: page}
+	if q.Has("cursor") {
+		first := maps.Clone(q)
+		first.Del("cursor")
+		data.FirstURL = "?" + first.Encode()
+	}
+	if page.Next != "" {
+		next := maps.Clone(q)
+		next.Set("cursor", page.Next)
+		data.NextURL = "?" + next.Encode()
+	}
+	if err := render(w, r, "pages/admin_users.html", data); err != nil {
+		internal(w, err)
+	}
+}
+
+var (
+	errSelfAction   = errors.New("admins cannot suspend, ban or delete their own account")
+	errInvalidUntil = errors.New("until must be a date no earlier than today")
+)
+
+type adminUserPage struct {
+	User     *repository.User
+	Roles    []string
+	Sessions []repository.Session
+	Events   []repository.AuditEvent
+	// Self is set when admins look at their own account.
+	Self bool
+}
+
+// AdminUser shows a user with their identities, roles, sessions and the
+// audit events that concern them.
+func (uh *UserHandler) AdminUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := uh.adminTarget(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	data := adminUserPage{User: user, Self: CurrentUser(ctx).ID == user.ID}
+
+	var err error
+	if data.Roles, err = uh.RS.UserRoles(ctx, user); err != nil {
+		internal(w, err)
+		return
+	}
+	if data.Sessions, err = uh.SS.ListUserSessions(ctx, user.ID); err != nil {
+		internal(w, err)
+		return
+	}
+	if data.Events, err = uh.AS.List(ctx, repository.AuditFilter{TargetUserID: user.ID}); err != nil {
+		internal(w, err)
+		return
+	}
+	if err := render(w, r, "pages/admin_user.html", data); err != nil {
+		internal(w, err)
+	}
+}
+
+// AdminRevokeSessions signs the user out everywhere.
+func (uh *UserHandler) AdminRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	uh.adminAction(w, r, func(user *repository.User) error {
+		return uh.SS.RevokeAllUserSessions(r.Context(), user.ID)
+	})
+}
+
+// AdminResetPassword replaces the user's password with the one posted.
+func (uh *UserHandler) AdminResetPassword(w http.ResponseWriter, r *http.Request) {
+	uh.adminAction(w, r, func(user *repository.User) error {
+		return uh.US.SetPassword(r.Context(), user, r.FormValue("password"))
+	})
+}
+
+// AdminClearPassword removes the user's password.
+func (uh *UserHandler) AdminClearPassword(w http.ResponseWriter, r *http.Request) {
+	uh.adminAction(w, r, func(user *repository.User) error {
+		return uh.US.ClearPassword(r.Context(), user)
+	})
+}
+
+// AdminVerifyEmail marks the user's email address as verified.
+func (uh *UserHandler) AdminVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	uh.adminAction(w, r, func(user *repository.User) error {
+		return uh.US.VerifyEmail(r.Context(), user)
+	})
+}
+
+// AdminSuspend suspends the user, which also signs them out, for the
+// posted reason and until the end of the posted date, if any.
+func (uh *UserHandler) AdminSuspend(w http.ResponseWriter, r *http.Request) {
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	var until time.Time
+	if v := r.FormValue("until"); v != "" {
+		day, err := time.Parse(time.DateOnly, v)
+		if err != nil || !day.AddDate(0, 0, 1).After(time.Now()) {
+			http.Error(w, errInvalidUntil.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		until = day.AddDate(0, 0, 1)
+	}
+	uh.adminAction(w, r, func(user *repository.User) error {
+		if user.ID == CurrentUser(r.Context()).ID {
+			return errSelfAction
+		}
+		return uh.US.Suspend(r.Context(), user, reason, until)
+	})
+}
+
+// AdminBan bans the user, which also signs them out, for the posted reason.
+func (uh *UserHandler) AdminBan(w http.ResponseWriter, r *http.Request) {
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	uh.adminAction(w, r, func(user *repository.User) error {
+		if user.ID == CurrentUser(r.Context()).ID {
+			return errSelfAction
+		}
+		return uh.US.Ban(r.Context(), user, reason)
+	})
+}
+
+// AdminReactivate lifts the user's suspension or ban.
+func (uh *UserHandler) AdminReactivate(w http.ResponseWriter, r *http.Request) {
+	uh.adminAction(w, r, func(user *repository.User) error {
+		return uh.US.Reactivate(r.Context(), user)
+	})
+}
+
+// AdminDelete deletes the user and returns to the user list.
+func (uh *UserHandler) AdminDelete(w http.ResponseWriter, r *http.Request) {
+	_, ok := uh.applyAdminAction(w, r, func(user *repository.User) error {
+		if user.ID == CurrentUser(r.Context()).ID {
+			return errSelfAction
+		}
+		return uh.US.Delete(r.Context(), user)
+	})
+	if ok {
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}
+}
+
+// adminAction applies act to the user named in the path and redirects to
+// the user's page. The services record the action in the audit trail.
+func (uh *UserHandler) adminAction(w http.ResponseWriter, r *http.Request, act func(*repository.User) error) {
+	if user, ok := uh.applyAdminAction(w, r, act); ok {
+		http.Redirect(w, r, "/admin/users/"+user.ID, http.StatusSeeOther)
+	}
+}
+
+// applyAdminAction applies act to the user named in the path, answering
+// with the error if it fails.
+func (uh *UserHandler) applyAdminAction(w http.ResponseWriter, r *http.Request, act func(*repository.User) error) (*repository.User, bool) {
+	user, ok := uh.adminTarget(w, r)
+	if !ok {
+		return nil, false
+	}
+	if err := act(user); err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidPassword), errors.Is(err, errInvalidUntil):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case errors.Is(err, errSelfAction), errors.Is(err, services.ErrNoOtherSignIn):
+			conflict(w, err.Error())
+		default:
+			internal(w, err)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// adminTarget returns the user named by the {id} path segment, answering
+// 404 when there is none.
+func (uh *UserHandler) adminTarget(w http.ResponseWriter, r *http.Request) (*repository.User, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	user, err := uh.US.Get(r.Context(), id.String())
+	if err != nil {
+		internal(w, err)
+		return nil, false
+	}
+	if user == nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return user, true
+}
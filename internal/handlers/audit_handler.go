@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"template/internal/repository"
+	"template/internal/services"
+
+	"github.com/google/uuid"
+)
+
+type auditPage struct {
+	Query  url.Values
+	Events []repository.AuditEvent
+	// FirstURL and NextURL keep the filters of the current page.
+	FirstURL, NextURL string
+}
+
+// Activity shows signed-in users the audit events that concern them.
+func (uh *UserHandler) Activity(w http.ResponseWriter, r *http.Request) {
+	uh.auditEvents(w, r, "pages/activity.html", repository.AuditFilter{TargetUserID: CurrentUser(r.Context()).ID})
+}
+
+// AdminAudit shows the audit trail of every user, filtered by actor, user
+// (the target) and type.
+func (uh *UserHandler) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := repository.AuditFilter{ActorID: q.Get("actor"), TargetUserID: q.Get("user"), Type: q.Get("type")}
+	for _, id := range []string{f.ActorID, f.TargetUserID} {
+		if _, err := uuid.Parse(id); id != "" && err != nil {
+			badRequest(w, fmt.Sprintf("invalid user ID %q", id))
+			return
+		}
+	}
+	uh.auditEvents(w, r, "pages/admin_audit.html", f)
+}
+
+// auditEvents renders one page of the events matching f. The before query
+// parameter is the ID of the last event of the previous page.
+func (uh *UserHandler) auditEvents(w http.ResponseWriter, r *http.Request, page string, f repository.AuditFilter) {
+	q := r.URL.Query()
+	if v := q.Get("before"); v != "" {
+		before, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || before <= 0 {
+			badRequest(w, fmt.Sprintf("invalid before %q", v))
+			return
+		}
+		f.Before = before
+	}
+
+	events, err := uh.AS.List(r.Context(), f)
+	if err != nil {
+		internal(w, err)
+		return
+	}
+	data := auditPage{Query: q, Events: events}
+	if q.Has("before") {
+		first := maps.Clone(q)
+		first.Del("before")
+		data.FirstURL = "?" + first.Encode()
+	}
+	// A full page may be followed by more.
+	if len(events) == services.DefaultAuditLimit {
+		next := maps.Clone(q)
+		next.Set("before", strconv.FormatInt(events[len(events)-1].ID, 10))
+		data.NextURL = "?" + next.Encode()
+	}
+	if err := render(w, r, page, data); err != nil {
+		internal(w, err)
+	}
+}
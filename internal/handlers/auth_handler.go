@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"context"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net"
 	"net/http"
 	"time"
@@ -15,16 +21,66 @@ import (
 	"golang.org/x/oauth2/google"
 )
 
-var (
-	googleOauthConfig = &oauth2.Config{
-		RedirectURL:  "http://localhost:80/auth/google/callback",
-		ClientID:     "",
-		ClientSecret: "",
-		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email"},
-		Endpoint:     google.Endpoint,
+// googleStateCookie holds the state of a Google sign-in in progress, which
+// the callback must echo. It is Lax so that it comes back on Google's
+// redirect.
+const googleStateCookie = "oauth_state"
+
+// GoogleOAuth signs users in with their Google account.
+type GoogleOAuth struct {
+	Config *oauth2.Config
+	// UserInfoURL serves the profile of the user a token belongs to.
+	UserInfoURL string
+}
+
+// NewGoogleOAuth returns the Google sign-in of the app registered as
+// clientID, whose callback is redirectURL.
+func NewGoogleOAuth(clientID, clientSecret, redirectURL string) *GoogleOAuth {
+	return &GoogleOAuth{
+		Config: &oauth2.Config{
+			RedirectURL:  redirectURL,
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			// The profile scope provides the name, picture and locale a
+			// new account's profile starts from.
+			Scopes: []string{
+				"https://www.googleapis.com/auth/userinfo.email",
+				"https://www.googleapis.com/auth/userinfo.profile",
+			},
+			Endpoint: google.Endpoint,
+		},
+		UserInfoURL: "https://www.googleapis.com/oauth2/v2/userinfo",
 	}
-	oauthStateString = "random"
-)
+}
+
+// userInfo exchanges code for a token and fetches the profile of its user.
+func (g *GoogleOAuth) userInfo(ctx context.Context, code string) (*repository.GoogleUser, error) {
+	token, err := g.Config.Exchange(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("google: exchange code: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.Config.Client(ctx, token).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("google: fetch user info: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("google: fetch user info: %s", resp.Status)
+	}
+	var info repository.GoogleUser
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&info); err != nil {
+		return nil, fmt.Errorf("google: decode user info: %w", err)
+	}
+	if info.Id == "" || info.Email == "" {
+		return nil, errors.New("google: user info without an id or email")
+	}
+	info.Email = utils.CleanString(info.Email)
+	return &info, nil
+}
 
 func (uh *UserHandler) GetRegister(w http.ResponseWriter, r *http.Request) {}
 func (uh *UserHandler) GetLogin(w http.ResponseWriter, r *http.Request)    {}
@@ -47,7 +103,7 @@ func (uh *UserHandler) PostRegister(w http.ResponseWriter, r *http.Request) {
 		PasswordHash: passwordHash,
 	}
 
-	created, err := uh.US.Create(r.Context(), u)
+	_, cookieHash, err := uh.US.Register(r.Context(), u, uh.SS, net.IP(utils.GetIPAddressBytes(r)), r.UserAgent())
 	if err != nil {
 		if errors.Is(err, services.ErrEmailAlreadyExist) {
 			conflict(w, err.Error())
@@ -56,32 +112,173 @@ func (uh *UserHandler) PostRegister(w http.ResponseWriter, r *http.Request) {
 		internal(w, err)
 		return
 	}
-	cookieHash, err := uh.SS.CreateSession(r.Context(), created.ID, net.IP(utils.GetIPAddressBytes(r)), r.UserAgent())
+	uh.M.Registered("password")
+	setSessionCookie(w, cookieHash)
+
+	http.Redirect(w, r, "/app", http.StatusSeeOther)
+}
+
+func (uh *UserHandler) PostLogin(w http.ResponseWriter, r *http.Request) {
+	email, pwd := utils.CleanString(r.FormValue("email")), r.FormValue("password")
+
+	if !utils.IsValidEmail(email) || !utils.IsValidPasswordLength(pwd) {
+		uh.M.LoginFailed("invalid_form")
+		unprocessable(w)
+		return
+	}
+
+	user, err := uh.US.Authenticate(r.Context(), email, pwd)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			uh.M.LoginFailed("invalid_credentials")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var inactive *services.InactiveAccountError
+		if errors.As(err, &inactive) {
+			uh.M.LoginFailed("inactive")
+			accountInactive(w, r, inactive.User)
+			return
+		}
 		internal(w, err)
 		return
 	}
 
-	// Set secure session cookie
+	cookieHash, err := uh.SS.SignIn(r.Context(), user, "password", net.IP(utils.GetIPAddressBytes(r)), r.UserAgent())
+	if err != nil {
+		internal(w, err)
+		return
+	}
+	uh.M.LoginSucceeded("password")
+	setSessionCookie(w, cookieHash)
+
+	http.Redirect(w, r, "/app", http.StatusSeeOther)
+}
+
+func (uh *UserHandler) PostLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie("session"); err == nil {
+		if err := uh.SS.Logout(r.Context(), cookie.Value); err != nil {
+			internal(w, err)
+			return
+		}
+	}
+	clearSessionCookie(w)
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// accountInactive explains to a user who may not sign in why not.
+func accountInactive(w http.ResponseWriter, r *http.Request, user *repository.User) {
+	w.WriteHeader(http.StatusForbidden)
+	if err := render(w, r, "pages/account_inactive.html", user); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// setSessionCookie sets the secure session cookie
+func setSessionCookie(w http.ResponseWriter, value string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session",
-		Value:    cookieHash,
+		Value:    value,
 		Path:     "/",
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
 		MaxAge:   int(24 * time.Hour.Seconds()),
 	})
+}
 
-	http.Redirect(w, r, "/app", http.StatusSeeOther)
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session",
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		MaxAge:   -1,
+	})
 }
-func (uh *UserHandler) PostLogin(w http.ResponseWriter, r *http.Request)  {}
-func (uh *UserHandler) PostLogout(w http.ResponseWriter, r *http.Request) {}
 
+// HandleGoogleLogin sends the user to Google to sign in, remembering the
+// state the callback must return.
 func (uh *UserHandler) HandleGoogleLogin(w http.ResponseWriter, r *http.Request) {
-	http.Redirect(w, r,
-		googleOauthConfig.AuthCodeURL(oauthStateString, oauth2.AccessTypeOffline),
-		http.StatusTemporaryRedirect)
+	state, err := utils.CSRFToken()
+	if err != nil {
+		internal(w, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     googleStateCookie,
+		Value:    state,
+		Path:     "/auth/google",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+	})
+	http.Redirect(w, r, uh.Google.Config.AuthCodeURL(state), http.StatusSeeOther)
 }
 
-func (uh *UserHandler) LoginWithGoogle(w http.ResponseWriter, r *http.Request) {}
+// LoginWithGoogle is the OAuth callback. It signs in the Google user,
+// registering them on their first sign-in, unless their account may not
+// sign in.
+func (uh *UserHandler) LoginWithGoogle(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(googleStateCookie)
+	http.SetCookie(w, &http.Cookie{
+		Name:     googleStateCookie,
+		Value:    "",
+		Path:     "/auth/google",
+		HttpOnly: true,
+		Secure:   true,
+		MaxAge:   -1,
+	})
+	state := r.FormValue("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie.Value)) != 1 {
+		uh.M.LoginFailed("invalid_state")
+		badRequest(w, "invalid OAuth state")
+		return
+	}
+	if r.FormValue("error") != "" {
+		// The user declined on Google's consent screen.
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	info, err := uh.Google.userInfo(r.Context(), r.FormValue("code"))
+	if err != nil {
+		log.Println(err.Error())
+		uh.M.LoginFailed("oauth_error")
+		http.Error(w, "Google sign-in failed", http.StatusBadGateway)
+		return
+	}
+	user, err := uh.US.RegisterGoogleUser(r.Context(), info)
+	if err != nil {
+		var inactive *services.InactiveAccountError
+		switch {
+		case errors.As(err, &inactive):
+			uh.M.LoginFailed("inactive")
+			accountInactive(w, r, inactive.User)
+		case errors.Is(err, services.ErrEmailAlreadyExist):
+			uh.M.LoginFailed("email_taken")
+			conflict(w, "an account already uses this email; sign in with its password")
+		default:
+			internal(w, err)
+		}
+		return
+	}
+
+	cookieHash, err := uh.SS.SignIn(r.Context(), user, "google", net.IP(utils.GetIPAddressBytes(r)), r.UserAgent())
+	if err != nil {
+		internal(w, err)
+		return
+	}
+	uh.M.LoginSucceeded("google")
+	setSessionCookie(w, cookieHash)
+
+	// The session cookie is SameSite=Strict, and browsers withhold it from
+	// a redirect chain that started on Google; a page of our own moves on
+	// to the app instead.
+	if err := render(w, r, "pages/signed_in.html", nil); err != nil {
+		log.Println(err.Error())
+	}
+}
@@ -0,0 +1,208 @@
+package handlers_test
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+
+	"template/internal/handlers"
+)
+
+var longText = strings.Repeat("hello, compression ", 100)
+
+func compressed(h http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	new(handlers.Middleware).CompressMiddleware(h).ServeHTTP(w, r)
+	return w
+}
+
+func textHandler(contentType, body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		io.WriteString(w, body)
+	})
+}
+
+func TestCompressNegotiation(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0", ""},
+		{"*", "br"},
+		{"*;q=0.1, gzip;q=0.5", "gzip"},
+		{"GZIP", "gzip"},
+		{"gzip;q=bogus, deflate", "deflate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			w := compressed(textHandler("text/plain", longText), tt.accept)
+			if got := w.Header().Get("Content-Encoding"); got != tt.want {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.want)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+		})
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+	for encoding, decode := range decoders {
+		t.Run(encoding, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Length", "1900")
+				w.Header().Set("ETag", `"v1"`)
+				io.WriteString(w, longText)
+			})
+			w := compressed(h, encoding)
+			if got := w.Header().Get("Content-Encoding"); got != encoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, encoding)
+			}
+			if got := w.Header().Get("Content-Length"); got != "" {
+				t.Errorf("Content-Length = %q, want it removed", got)
+			}
+			if got := w.Header().Get("ETag"); got != `W/"v1"` {
+				t.Errorf("ETag = %q, want weak", got)
+			}
+			r, err := decode(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != longText {
+				t.Errorf("decoded body differs from the original")
+			}
+		})
+	}
+}
+
+func TestCompressSkips(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"short body", textHandler("text/html", "<p>hi</p>")},
+		{"image", textHandler("image/png", longText)},
+		{"zip", textHandler("application/zip", longText)},
+		{"already encoded", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "gzip")
+			io.WriteString(w, longText)
+		})},
+		{"no content", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			w := compressed(tt.handler, "gzip")
+			if w.Code != rec.Code {
+				t.Errorf("status = %d, want %d", w.Code, rec.Code)
+			}
+			if got, want := w.Header().Get("Content-Encoding"), rec.Header().Get("Content-Encoding"); got != want {
+				t.Errorf("Content-Encoding = %q, want %q", got, want)
+			}
+			if w.Body.String() != rec.Body.String() {
+				t.Errorf("body was modified")
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+		})
+	}
+}
+
+func TestCompressRangeAndHead(t *testing.T) {
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		r := httptest.NewRequest(method, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		if method == http.MethodGet {
+			r.Header.Set("Range", "bytes=0-10")
+		}
+		w := httptest.NewRecorder()
+		new(handlers.Middleware).CompressMiddleware(textHandler("text/plain", longText)).ServeHTTP(w, r)
+		if got := w.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("%s: Content-Encoding = %q, want none", method, got)
+		}
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	flushed := make(chan struct{})
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		http.NewResponseController(w).Flush()
+		close(flushed)
+		<-release
+		io.WriteString(w, "data: second\n\n")
+	})
+	srv := httptest.NewServer(new(handlers.Middleware).CompressMiddleware(h))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	<-flushed
+
+	// A short body is still compressed once the handler flushes, and the
+	// first event arrives before the handler returns.
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, len("data: first\n\n"))
+	if _, err := io.ReadFull(zr, first); err != nil {
+		t.Fatal(err)
+	}
+	if string(first) != "data: first\n\n" {
+		t.Errorf("first chunk = %q", first)
+	}
+
+	close(release)
+	rest, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "data: second\n\n" {
+		t.Errorf("rest = %q", rest)
+	}
+}
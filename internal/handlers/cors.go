@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures CORS for one route group.
+type CORSOptions struct {
+	// AllowedOrigins lists exact origins ("https://app.example.com"),
+	// wildcard subdomains ("https://*.example.com") or "*" for any origin.
+	// A wildcard subdomain matches only the port it names, if any; "*"
+	// never allows credentials.
+	AllowedOrigins []string
+	// AllowedMethods defaults to GET, HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders lists request headers a client may send; "*" allows any.
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CORS answers preflight requests and adds CORS headers to actual requests
+// whose Origin is allowed. It must wrap the group's mux rather than its
+// handlers: method-scoped patterns such as "POST /items" would otherwise
+// make the mux reply 405 to the preflight OPTIONS before CORS sees it.
+func (m *Middleware) CORS(opts CORSOptions) Mw {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+	anyHeader := slices.Contains(opts.AllowedHeaders, "*")
+	maxAge := ""
+	if opts.MaxAge > 0 {
+		maxAge = strconv.Itoa(int(opts.MaxAge.Seconds()))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Add("Vary", "Origin")
+
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed := originAllowed(opts.AllowedOrigins, origin)
+			if !preflight {
+				if allowed {
+					setAllowOrigin(h, opts, origin)
+					if exposed != "" {
+						h.Set("Access-Control-Expose-Headers", exposed)
+					}
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+
+			method := r.Header.Get("Access-Control-Request-Method")
+			if !allowed || !slices.Contains(opts.AllowedMethods, method) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			requested := r.Header.Get("Access-Control-Request-Headers")
+			for _, name := range strings.Split(requested, ",") {
+				name = strings.TrimSpace(name)
+				if name == "" || anyHeader {
+					continue
+				}
+				if !slices.ContainsFunc(opts.AllowedHeaders, func(a string) bool { return strings.EqualFold(a, name) }) {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+
+			setAllowOrigin(h, opts, origin)
+			h.Set("Access-Control-Allow-Methods", methods)
+			if requested != "" {
+				h.Set("Access-Control-Allow-Headers", requested)
+			}
+			if maxAge != "" {
+				h.Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// setAllowOrigin echoes the origin back, except for a "*" policy which is
+// answered with the literal wildcard and never with credentials: echoing
+// the origin would let any site make credentialed requests.
+func setAllowOrigin(h http.Header, opts CORSOptions, origin string) {
+	if slices.Contains(opts.AllowedOrigins, "*") {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if opts.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// originAllowed matches origin against exact and "scheme://*.domain[:port]"
+// entries. A wildcard entry matches subdomains of domain on exactly the
+// port it names, or on the scheme's default port when it names none.
+func originAllowed(allowed []string, origin string) bool {
+	o, err := url.Parse(origin)
+	if err != nil || o.Scheme == "" || o.Host == "" {
+		return false
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(a, origin) {
+			return true
+		}
+		scheme, rest, ok := strings.Cut(a, "://*.")
+		if !ok || !strings.EqualFold(scheme, o.Scheme) {
+			continue
+		}
+		domain, port, _ := strings.Cut(rest, ":")
+		if o.Port() != port {
+			continue
+		}
+		if strings.HasSuffix(strings.ToLower(o.Hostname()), "."+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
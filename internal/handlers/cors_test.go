@@ -0,0 +1,173 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"template/internal/handlers"
+)
+
+func TestCORS(t *testing.T) {
+	exact := handlers.CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedHeaders:   []string{"Content-Type"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+	subdomains := handlers.CORSOptions{
+		AllowedOrigins:   []string{"https://*.example.com", "http://*.example.test:8080"},
+		AllowCredentials: true,
+	}
+	anyOrigin := handlers.CORSOptions{
+		AllowedOrigins: []string{"*"},
+		AllowedHeaders: []string{"*"},
+	}
+	// A "*" policy never answers with credentials, even if misconfigured.
+	anyWithCredentials := handlers.CORSOptions{
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+	}
+
+	tests := []struct {
+		name    string
+		opts    handlers.CORSOptions
+		method  string
+		origin  string
+		headers map[string]string
+
+		wantStatus      int
+		wantOrigin      string
+		wantCredentials string
+		wantHeaders     map[string]string
+	}{
+		{
+			name: "no origin", opts: exact, method: http.MethodGet,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "exact origin", opts: exact, method: http.MethodGet, origin: "https://app.example.com",
+			wantStatus: http.StatusOK, wantOrigin: "https://app.example.com", wantCredentials: "true",
+			wantHeaders: map[string]string{"Access-Control-Expose-Headers": "X-Request-ID"},
+		},
+		{
+			name: "other origin", opts: exact, method: http.MethodGet, origin: "https://evil.example",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "preflight", opts: exact, method: http.MethodOptions, origin: "https://app.example.com",
+			headers: map[string]string{
+				"Access-Control-Request-Method":  http.MethodPost,
+				"Access-Control-Request-Headers": "content-type",
+			},
+			wantStatus: http.StatusNoContent, wantOrigin: "https://app.example.com", wantCredentials: "true",
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Methods": "GET, HEAD, POST",
+				"Access-Control-Allow-Headers": "content-type",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name: "preflight disallowed method", opts: exact, method: http.MethodOptions, origin: "https://app.example.com",
+			headers:    map[string]string{"Access-Control-Request-Method": http.MethodDelete},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "preflight disallowed header", opts: exact, method: http.MethodOptions, origin: "https://app.example.com",
+			headers: map[string]string{
+				"Access-Control-Request-Method":  http.MethodPost,
+				"Access-Control-Request-Headers": "X-Custom",
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "preflight disallowed origin", opts: exact, method: http.MethodOptions, origin: "https://evil.example",
+			headers:    map[string]string{"Access-Control-Request-Method": http.MethodGet},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "subdomain", opts: subdomains, method: http.MethodGet, origin: "https://api.example.com",
+			wantStatus: http.StatusOK, wantOrigin: "https://api.example.com", wantCredentials: "true",
+		},
+		{
+			name: "nested subdomain", opts: subdomains, method: http.MethodGet, origin: "https://a.b.example.com",
+			wantStatus: http.StatusOK, wantOrigin: "https://a.b.example.com", wantCredentials: "true",
+		},
+		{
+			name: "bare domain", opts: subdomains, method: http.MethodGet, origin: "https://example.com",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "suffix lookalike", opts: subdomains, method: http.MethodGet, origin: "https://evilexample.com",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "subdomain wrong scheme", opts: subdomains, method: http.MethodGet, origin: "http://api.example.com",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "subdomain unlisted port", opts: subdomains, method: http.MethodGet, origin: "https://api.example.com:8443",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "subdomain listed port", opts: subdomains, method: http.MethodGet, origin: "http://dev.example.test:8080",
+			wantStatus: http.StatusOK, wantOrigin: "http://dev.example.test:8080", wantCredentials: "true",
+		},
+		{
+			name: "subdomain missing port", opts: subdomains, method: http.MethodGet, origin: "http://dev.example.test",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "any origin", opts: anyOrigin, method: http.MethodGet, origin: "https://anywhere.example",
+			wantStatus: http.StatusOK, wantOrigin: "*",
+		},
+		{
+			name: "any origin preflight", opts: anyOrigin, method: http.MethodOptions, origin: "https://anywhere.example",
+			headers: map[string]string{
+				"Access-Control-Request-Method":  http.MethodGet,
+				"Access-Control-Request-Headers": "X-Anything",
+			},
+			wantStatus: http.StatusNoContent, wantOrigin: "*",
+		},
+		{
+			name: "any origin with credentials", opts: anyWithCredentials, method: http.MethodGet, origin: "https://anywhere.example",
+			wantStatus: http.StatusOK, wantOrigin: "*",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			h := new(handlers.Middleware).CORS(tt.opts)(next)
+
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+			for k, want := range tt.wantHeaders {
+				if got := w.Header().Get(k); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+			if vary := w.Header().Values("Vary"); len(vary) == 0 || vary[0] != "Origin" {
+				t.Errorf("Vary = %q, want Origin first", vary)
+			}
+		})
+	}
+}
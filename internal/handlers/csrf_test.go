@@ -0,0 +1,48 @@
+package handlers_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"template/internal/handlers"
+)
+
+func TestCSRFForm(t *testing.T) {
+	const token = "s3cret"
+	var multi bytes.Buffer
+	mw := multipart.NewWriter(&multi)
+	mw.WriteField("csrf_token", token)
+	mw.Close()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"urlencoded", "application/x-www-form-urlencoded", "csrf_token=" + token, http.StatusOK},
+		{"multipart", mw.FormDataContentType(), multi.String(), http.StatusOK},
+		{"forged", "application/x-www-form-urlencoded", "csrf_token=forged", http.StatusForbidden},
+		{"not a form", "text/plain", "csrf_token=" + token, http.StatusForbidden},
+		{"too large", "application/x-www-form-urlencoded", "csrf_token=" + token + "&pad=" + strings.Repeat("x", 1<<10), http.StatusRequestEntityTooLarge},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := new(handlers.Middleware).CSRFMiddleware(next)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			r.AddCookie(&http.Cookie{Name: "csrf_token", Value: token})
+			w := httptest.NewRecorder()
+			r.Body = http.MaxBytesReader(w, r.Body, 512)
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"template/internal/handlers"
+	"template/internal/repository"
+	"template/internal/repository/memory"
+	"template/internal/services"
+)
+
+// fakeGoogle serves the token and user info endpoints, answering every
+// exchange with the profile in info.
+func fakeGoogle(t *testing.T, info *repository.GoogleUser) *handlers.GoogleOAuth {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "token", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("GET /userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(info)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	g := handlers.NewGoogleOAuth("client", "secret", "https://app.example.com/auth/google/callback")
+	g.Config.Endpoint = oauth2.Endpoint{AuthURL: srv.URL + "/auth", TokenURL: srv.URL + "/token"}
+	g.UserInfoURL = srv.URL + "/userinfo"
+	return g
+}
+
+func TestGoogleCallback(t *testing.T) {
+	store := memory.NewStore()
+	us := services.NewUserService(store.Users(), store, nil, nil)
+	info := &repository.GoogleUser{
+		Id: "g-1", Email: "Ada@Example.com", VerifiedEmail: true,
+		Name: "Ada Lovelace", Locale: "en-GB", Picture: "https://lh3.example.com/ada.png",
+	}
+	uh := handlers.UserHandler{
+		US:     us,
+		SS:     services.NewSessionService(store.Sessions(), store, nil),
+		Google: fakeGoogle(t, info),
+	}
+
+	// start begins a sign-in and returns the state Google would echo.
+	start := func() *http.Cookie {
+		w := httptest.NewRecorder()
+		uh.HandleGoogleLogin(w, httptest.NewRequest(http.MethodGet, "/auth/google/login", nil))
+		loc, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scope := loc.Query().Get("scope"); !strings.Contains(scope, "userinfo.profile") {
+			t.Errorf("scope = %q, want the profile", scope)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Value != loc.Query().Get("state") {
+			t.Fatalf("state cookie %v does not match the state in %s", cookies, loc)
+		}
+		return cookies[0]
+	}
+	callback := func(state *http.Cookie, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/auth/google/callback?"+query, nil)
+		if state != nil {
+			r.AddCookie(state)
+		}
+		w := httptest.NewRecorder()
+		uh.LoginWithGoogle(w, r)
+		return w
+	}
+	query := func(state, code string) string {
+		return url.Values{"state": {state}, "code": {code}}.Encode()
+	}
+	session := func(w *httptest.ResponseRecorder) string {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "session" {
+				return c.Value
+			}
+		}
+		return ""
+	}
+
+	state := start()
+	if w := callback(nil, query(state.Value, "good-code")); w.Code != http.StatusBadRequest {
+		t.Errorf("callback without the state cookie: status = %d, want 400", w.Code)
+	}
+	if w := callback(state, query("forged", "good-code")); w.Code != http.StatusBadRequest {
+		t.Errorf("callback with another state: status = %d, want 400", w.Code)
+	}
+	if w := callback(state, query(state.Value, "bad-code")); w.Code != http.StatusBadGateway || session(w) != "" {
+		t.Errorf("callback with a rejected code: status = %d, want 502 and no session", w.Code)
+	}
+
+	// The first sign-in registers the user, with a profile from their
+	// Google claims.
+	w := callback(state, query(state.Value, "good-code"))
+	if w.Code != http.StatusOK || session(w) == "" {
+		t.Fatalf("callback: status = %d, session %q", w.Code, session(w))
+	}
+	u, err := us.GetByEmail(t.Context(), "ada@example.com")
+	if err != nil || u == nil || u.GoogleID.String != "g-1" {
+		t.Fatalf("registered user = %+v, %v", u, err)
+	}
+	if u.DisplayName != info.Name || u.Locale != info.Locale || u.AvatarURL != info.Picture {
+		t.Errorf("profile = %q, %q, %q, want it from the Google claims", u.DisplayName, u.Locale, u.AvatarURL)
+	}
+
+	// A suspended user is shown why they cannot sign in.
+	if err := us.Suspend(t.Context(), u, "spam", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	state = start()
+	w = callback(state, query(state.Value, "good-code"))
+	if w.Code != http.StatusForbidden || session(w) != "" || !strings.Contains(w.Body.String(), "suspended") {
+		t.Errorf("callback for a suspended user: status = %d, session %q", w.Code, session(w))
+	}
+}
@@ -1,24 +1,62 @@
 package handlers
 
 import (
+	"encoding/json"
 	"html/template"
 	"io/fs"
 	"log"
 	"net/http"
+	"path"
 
 	"template/web"
 )
 
 var (
-	HTMLFS, _  = fs.Sub(web.FileFS, "templates")
-	FrameFS, _ = fs.Sub(web.FileFS, "templates/frame")
+	HTMLFS, _   = fs.Sub(web.FileFS, "templates")
+	FrameFS, _  = fs.Sub(web.FileFS, "templates/frame")
+	StaticFS, _ = fs.Sub(web.FileFS, "static")
 )
 
+// render executes page from HTMLFS with the per-request template helpers
+// and the partials, e.g. {{template "impersonation"}}.
+func render(w http.ResponseWriter, r *http.Request, page string, data any) error {
+	t, err := template.New(path.Base(page)).Funcs(templateFuncs(r)).ParseFS(HTMLFS, page, "partials/*.html")
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, data)
+}
+
+// templateFuncs are the helpers available to every page, e.g.
+// <script nonce="{{cspNonce}}">,
+// <input type="hidden" name="csrf_token" value="{{csrfToken}}"> or
+// {{if can "users:read"}}<a href="/admin/users">Users</a>{{end}} and
+// {{with avatarURL .User}}<img src="{{.}}" alt="">{{end}}.
+func templateFuncs(r *http.Request) template.FuncMap {
+	return template.FuncMap{
+		"cspNonce":      func() string { return CSPNonce(r.Context()) },
+		"csrfToken":     func() string { return CSRFToken(r.Context()) },
+		"can":           func(perm string) bool { return Can(r.Context(), perm) },
+		"impersonating": func() *Impersonation { return Impersonating(r.Context()) },
+		"avatarURL":     avatarURL,
+	}
+}
+
 func renderFrame(w http.ResponseWriter, frame string, data any) error {
 	t := template.Must(template.ParseFS(FrameFS, frame))
 	return t.Execute(w, data)
 }
 
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func jsonError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
 func unprocessable(w http.ResponseWriter) { w.WriteHeader(http.StatusUnprocessableEntity) }
 
 func badRequest(w http.ResponseWriter, msg string) {
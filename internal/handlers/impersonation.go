@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"template/internal/repository"
+	"template/internal/services"
+	"template/utils"
+)
+
+const impkey userctx = "impersonation"
+
+// impersonationCookie names the cookie of an impersonation. It is scoped
+// to /app, so the admin pages stay the admin's own meanwhile.
+const impersonationCookie = "impersonation"
+
+// Impersonation is an admin using the app as another user.
+type Impersonation struct {
+	Admin     *repository.User
+	Target    *repository.User
+	ExpiresAt time.Time
+}
+
+// Impersonating returns the impersonation the request is part of, or nil.
+// During one, CurrentUser is the impersonated user.
+func Impersonating(ctx context.Context) *Impersonation {
+	imp, _ := ctx.Value(impkey).(*Impersonation)
+	return imp
+}
+
+// impersonate returns the impersonation admin started through the
+// request's impersonation cookie, if any. ctx must carry admin as the
+// current user. It ends when the target may no longer sign in or the
+// admin loses users:impersonate; a stale cookie is cleared.
+func (m *Middleware) impersonate(ctx context.Context, w http.ResponseWriter, r *http.Request, admin *repository.User) *Impersonation {
+	cookie, err := r.Cookie(impersonationCookie)
+	if err != nil {
+		return nil
+	}
+	imp, err := m.impersonationService.Resolve(ctx, cookie.Value, admin)
+	if err != nil {
+		clearImpersonationCookie(w)
+		return nil
+	}
+	target, err := m.userService.Get(ctx, imp.TargetUserID)
+	if err == nil && target != nil && target.Active() && Can(ctx, repository.PermUsersImpersonate) {
+		return &Impersonation{Admin: admin, Target: target, ExpiresAt: imp.ExpiresAt}
+	}
+	if err == nil {
+		_, err = m.impersonationService.Stop(ctx, cookie.Value, admin)
+	}
+	if err != nil {
+		log.Println(err.Error())
+	}
+	clearImpersonationCookie(w)
+	return nil
+}
+
+// withImpersonation returns ctx in which the impersonated user is the
+// current user, while the admin remains the actor of the audit trail.
+func (m *Middleware) withImpersonation(ctx context.Context, imp *Impersonation) context.Context {
+	ctx = m.withUser(ctx, imp.Target)
+	info := services.RequestInfoFrom(ctx)
+	info.ActorID, info.Impersonating = imp.Admin.ID, imp.Target.ID
+	ctx = services.WithRequestInfo(ctx, info)
+	return context.WithValue(ctx, impkey, imp)
+}
+
+// DenyImpersonation refuses the sensitive actions, such as changing
+// credentials or deleting the account, that an impersonating admin may
+// not take on the user's behalf.
+func (m *Middleware) DenyImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Impersonating(r.Context()) != nil {
+			http.Error(w, "not allowed while impersonating a user", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminImpersonate starts impersonating the user named in the path and
+// opens the app as them.
+func (uh *UserHandler) AdminImpersonate(w http.ResponseWriter, r *http.Request) {
+	target, ok := uh.adminTarget(w, r)
+	if !ok {
+		return
+	}
+	imp, err := uh.IS.Start(r.Context(), CurrentUser(r.Context()), target, net.IP(utils.GetIPAddressBytes(r)), r.UserAgent())
+	if err != nil {
+		if errors.Is(err, services.ErrCannotImpersonate) {
+			conflict(w, err.Error())
+			return
+		}
+		internal(w, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonationCookie,
+		Value:    imp.CookieHash,
+		Path:     "/app",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  imp.ExpiresAt,
+	})
+	http.Redirect(w, r, "/app/", http.StatusSeeOther)
+}
+
+// StopImpersonation ends the current user's impersonation and returns the
+// admin to the user's admin page. Only the admin who started one can stop
+// it.
+func (uh *UserHandler) StopImpersonation(w http.ResponseWriter, r *http.Request) {
+	next := "/app/"
+	admin := CurrentUser(r.Context())
+	if imp := Impersonating(r.Context()); imp != nil {
+		admin = imp.Admin
+	}
+	if cookie, err := r.Cookie(impersonationCookie); err == nil {
+		imp, err := uh.IS.Stop(r.Context(), cookie.Value, admin)
+		switch {
+		case err == nil:
+			next = "/admin/users/" + imp.TargetUserID
+		case !errors.Is(err, services.ErrNotImpersonating):
+			internal(w, err)
+			return
+		}
+	}
+	clearImpersonationCookie(w)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func clearImpersonationCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     impersonationCookie,
+		Value:    "",
+		Path:     "/app",
+		HttpOnly: true,
+		Secure:   true,
+		MaxAge:   -1,
+	})
+}
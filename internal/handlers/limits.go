@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// timeoutPage is the body sent when a handler exceeds its Timeout.
+const timeoutPage = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Service unavailable</title></head>
+<body>
+<h1>Service unavailable</h1>
+<p>The server took too long to respond. Please try again in a moment.</p>
+</body>
+</html>`
+
+// limitedBody remembers the unlimited body so that an inner BodyLimit
+// can replace the limit set by an outer one instead of stacking on it.
+type limitedBody struct {
+	io.ReadCloser
+	orig io.ReadCloser
+}
+
+// BodyLimit caps the request body at n bytes with http.MaxBytesReader.
+// Applied to a route or group, it overrides the limit set further out,
+// e.g. a larger limit for uploads.
+func (m *Middleware) BodyLimit(n int64) Mw {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body := r.Body
+			if lb, ok := body.(*limitedBody); ok {
+				body = lb.orig
+			}
+			r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, body, n), orig: body}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Timeout cancels the request context after d and answers 503 with
+// timeoutPage if the handler has not responded by then. The response is
+// buffered, so it must not wrap streaming handlers.
+func (m *Middleware) Timeout(d time.Duration) Mw {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, d, timeoutPage)
+	}
+}
@@ -2,28 +2,60 @@ package handlers
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"template/internal/metrics"
+	"template/internal/repository"
 	"template/internal/services"
 	"template/utils"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type userctx string
 
-const userkey userctx = "user"
+const (
+	userkey  userctx = "user"
+	routekey userctx = "route"
+	noncekey userctx = "csp_nonce"
+	csrfkey  userctx = "csrf_token"
+	permkey  userctx = "permissions"
+)
+
+// maxFormMemory is how much of a multipart form is held in memory, the
+// same as http.Request.FormValue's; the rest of a file spills to disk.
+const maxFormMemory = 32 << 20
 
 type Middleware struct {
-	userService    *services.UserService
-	sessionService *services.SessionService
+	userService          *services.UserService
+	sessionService       *services.SessionService
+	impersonationService *services.ImpersonationService
+	roleService          *services.RoleService
+	metrics              *metrics.Metrics
 }
 
-func NewMiddleware(us *services.UserService, ss *services.SessionService) *Middleware {
+func NewMiddleware(us *services.UserService, ss *services.SessionService, is *services.ImpersonationService, rs *services.RoleService, m *metrics.Metrics) *Middleware {
 	return &Middleware{
-		userService:    us,
-		sessionService: ss,
+		userService:          us,
+		sessionService:       ss,
+		impersonationService: is,
+		roleService:          rs,
+		metrics:              m,
 	}
 }
 
@@ -46,6 +78,17 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (m *Middleware) WithLogging(logger *slog.Logger) Mw {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -58,8 +101,13 @@ func (m *Middleware) WithLogging(logger *slog.Logger) Mw {
 				requestID = fmt.Sprintf("%d", time.Now().UnixNano())
 			}
 			w.Header().Set("X-Request-ID", requestID)
+			r = r.WithContext(services.WithRequestInfo(r.Context(), services.RequestInfo{
+				IPAddress: net.IP(utils.GetIPAddressBytes(r)),
+				UserAgent: r.UserAgent(),
+				RequestID: requestID,
+			}))
 
-			logger.Info("request started",
+			logger.InfoContext(r.Context(), "request started",
 				"request_id", requestID,
 				"method", r.Method,
 				"path", r.URL.Path,
@@ -71,7 +119,7 @@ func (m *Middleware) WithLogging(logger *slog.Logger) Mw {
 
 			next.ServeHTTP(rw, r)
 
-			logger.Info("request completed",
+			logger.InfoContext(r.Context(), "request completed",
 				"request_id", requestID,
 				"method", r.Method,
 				"path", r.URL.Path,
@@ -83,68 +131,315 @@ func (m *Middleware) WithLogging(logger *slog.Logger) Mw {
 	}
 }
 
+// RequestID returns the ID WithLogging gave the request.
+func RequestID(ctx context.Context) string {
+	return services.RequestInfoFrom(ctx).RequestID
+}
+
+// route holds the ServeMux pattern that matched a request, so it can be
+// read back once the request has gone through nested muxes. It is locked
+// because Timeout runs the handler on its own goroutine.
+type route struct {
+	mu      sync.Mutex
+	pattern string
+}
+
+// withRoute returns r carrying a route holder, reusing the one already
+// in its context if an outer middleware installed it.
+func withRoute(r *http.Request) (*http.Request, *route) {
+	if rt, ok := r.Context().Value(routekey).(*route); ok {
+		return r, rt
+	}
+	rt := &route{}
+	return r.WithContext(context.WithValue(r.Context(), routekey, rt)), rt
+}
+
+// label returns the matched pattern, or "unmatched" for requests no mux handled.
+func (rt *route) label() string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.pattern == "" {
+		return "unmatched"
+	}
+	return rt.pattern
+}
+
+// set records pattern unless a more deeply nested mux already did.
+func (rt *route) set(pattern string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.pattern == "" {
+		rt.pattern = pattern
+	}
+}
+
+// WithMetrics records request counts, latency and in-flight requests,
+// labelled by the matched route pattern instead of the raw path.
+func (m *Middleware) WithMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		m.metrics.RequestStarted()
+
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		r, rt := withRoute(r)
+		// Deferred so a handler that aborts with a panic is still counted.
+		defer func() {
+			m.metrics.RequestFinished(r.Method, rt.label(), strconv.Itoa(rw.statusCode), time.Since(start).Seconds())
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// WithTracing starts a server span for each request, continuing the trace
+// from an incoming W3C traceparent header when there is one.
+func (m *Middleware) WithTracing(next http.Handler) http.Handler {
+	tracer := otel.Tracer("template/internal/handlers")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+				semconv.ClientAddress(r.RemoteAddr),
+			),
+		)
+		defer span.End()
+
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		r, rt := withRoute(r.WithContext(ctx))
+
+		next.ServeHTTP(rw, r)
+
+		span.SetName(r.Method + " " + rt.label())
+		span.SetAttributes(
+			semconv.HTTPRoute(rt.label()),
+			semconv.HTTPResponseStatusCode(rw.statusCode),
+		)
+		if rw.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rw.statusCode))
+		}
+	})
+}
+
+// RecordRoute reports the pattern matched by the mux it wraps, mounted
+// under prefix, so WithMetrics and WithTracing see "/app/dashboard"
+// rather than "/app/". It must wrap the mux directly; the innermost
+// RecordRoute wins.
+func (m *Middleware) RecordRoute(prefix string) Mw {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if rt, ok := r.Context().Value(routekey).(*route); ok && r.Pattern != "" {
+					rt.set(prefix + patternPath(r.Pattern))
+				}
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// patternPath drops the method and host from a ServeMux pattern.
+func patternPath(pattern string) string {
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		pattern = path
+	}
+	if i := strings.Index(pattern, "/"); i > 0 {
+		pattern = pattern[i:]
+	}
+	return pattern
+}
+
 func (m *Middleware) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session")
-		if err != nil {
+		user, ok := m.authenticate(w, r)
+		if !ok {
+			if user != nil {
+				accountInactive(w, r, user)
+				return
+			}
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-
-		session, err := m.sessionService.ValidateSession(r.Context(), cookie.Value)
-		if err != nil {
-			http.SetCookie(w, &http.Cookie{
-				Name:     "session",
-				Value:    "",
-				Path:     "/",
-				HttpOnly: true,
-				Secure:   true,
-				MaxAge:   -1,
-			})
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		ctx := m.withUser(r.Context(), user)
+		if imp := m.impersonate(ctx, w, r, user); imp != nil {
+			next.ServeHTTP(w, r.WithContext(m.withImpersonation(r.Context(), imp)))
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		user, err := m.userService.UR.GetUserByID(r.Context(), session.UserID)
-		if err != nil {
-			_ = m.sessionService.RevokeSession(r.Context(), cookie.Value)
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+// APIAuthMiddleware is AuthMiddleware for JSON endpoints: it answers 401
+// instead of redirecting to the login page.
+func (m *Middleware) APIAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := m.authenticate(w, r)
+		if !ok {
+			if user != nil {
+				jsonError(w, http.StatusForbidden, (&services.InactiveAccountError{User: user}).Error())
+				return
+			}
+			jsonError(w, http.StatusUnauthorized, "authentication required")
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(m.withUser(r.Context(), user)))
+	})
+}
 
-		ctx := context.WithValue(r.Context(), userkey, user)
-		next.ServeHTTP(w, r.WithContext(ctx))
+// authenticate resolves the session cookie to its user. An invalid or
+// expired session has its cookie cleared. The session of a user who may no
+// longer sign in is revoked, and the user returned with ok false.
+func (m *Middleware) authenticate(w http.ResponseWriter, r *http.Request) (*repository.User, bool) {
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return nil, false
+	}
+
+	session, err := m.sessionService.ValidateSession(r.Context(), cookie.Value)
+	if err != nil {
+		if errors.Is(err, services.ErrSessionExpired) {
+			m.metrics.SessionValidated("expired")
+		} else {
+			m.metrics.SessionValidated("invalid")
+		}
+		clearSessionCookie(w)
+		return nil, false
+	}
+	m.metrics.SessionValidated("valid")
+
+	user, err := m.userService.Get(r.Context(), session.UserID)
+	if err != nil || user == nil {
+		_ = m.sessionService.RevokeSession(r.Context(), cookie.Value)
+		return nil, false
+	}
+	if !user.Active() {
+		_ = m.sessionService.RevokeSession(r.Context(), cookie.Value)
+		clearSessionCookie(w)
+		return user, false
+	}
+	return user, true
+}
+
+// withUser returns ctx carrying the authenticated user, who is also the
+// actor of the request, and an empty cache of their permissions.
+func (m *Middleware) withUser(ctx context.Context, user *repository.User) context.Context {
+	info := services.RequestInfoFrom(ctx)
+	info.ActorID = user.ID
+	ctx = services.WithRequestInfo(ctx, info)
+	ctx = context.WithValue(ctx, userkey, user)
+	return context.WithValue(ctx, permkey, &permissionCache{
+		load: func(ctx context.Context) (services.Permissions, error) {
+			return m.roleService.Permissions(ctx, user)
+		},
 	})
 }
 
+// CurrentUser returns the user authenticated by AuthMiddleware, or nil
+// outside the protected routes.
+func CurrentUser(ctx context.Context) *repository.User {
+	user, _ := ctx.Value(userkey).(*repository.User)
+	return user
+}
+
+// CSRFMiddleware implements double-submit CSRF protection. A request
+// without a csrf_token cookie is issued one; requests with unsafe methods
+// must echo its value in the csrf_token form field or the X-CSRF-Token
+// header. Templates read the token through the csrfToken helper.
 func (m *Middleware) CSRFMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			next.ServeHTTP(w, r)
-			return
+		var token string
+		if c, err := r.Cookie("csrf_token"); err == nil {
+			token = c.Value
 		}
 
-		csrfCookie, err := r.Cookie("csrf_token")
-		if err != nil {
-			http.Error(w, "CSRF token missing", http.StatusForbidden)
-			return
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if token == "" {
+				var err error
+				if token, err = utils.CSRFToken(); err != nil {
+					internal(w, err)
+					return
+				}
+				setCSRFCookie(w, token)
+			}
+		default:
+			if token == "" {
+				http.Error(w, "CSRF token missing", http.StatusForbidden)
+				return
+			}
+			sent := r.Header.Get("X-CSRF-Token")
+			if sent == "" {
+				// A body over the route's limit cannot be parsed for the
+				// token; say so rather than blame the token.
+				var tooLarge *http.MaxBytesError
+				if err := parseForm(r); errors.As(err, &tooLarge) {
+					http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				sent = r.FormValue("csrf_token")
+			}
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
 		}
 
-		csrfToken := r.FormValue("csrf_token")
-		if csrfToken == "" || csrfToken != csrfCookie.Value {
-			http.Error(w, "Invalid CSRF token", http.StatusForbidden)
-			return
-		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfkey, token)))
+	})
+}
 
-		next.ServeHTTP(w, r)
+// parseForm parses the request's form, reading a multipart body only when
+// the request declares one.
+func parseForm(r *http.Request) error {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mt == "multipart/form-data" {
+		return r.ParseMultipartForm(maxFormMemory)
+	}
+	return r.ParseForm()
+}
+
+// CSRFToken returns the request's CSRF token set by CSRFMiddleware.
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfkey).(string)
+	return token
+}
+
+func setCSRFCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "csrf_token",
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
 	})
 }
 
+// Except applies mw to every request but those for the given paths, such
+// as health probes that must not be rate limited.
+func (m *Middleware) Except(mw Mw, paths ...string) Mw {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(paths, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (m *Middleware) RateLimitMiddleware(next http.Handler) http.Handler {
 	type client struct {
 		count     int
 		lastReset time.Time
 	}
+	var mu sync.Mutex
 	clients := make(map[string]*client)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -157,6 +452,7 @@ func (m *Middleware) RateLimitMiddleware(next http.Handler) http.Handler {
 		ipStr := string(ip)
 		now := time.Now()
 
+		mu.Lock()
 		c, exists := clients[ipStr]
 		if !exists {
 			c = &client{lastReset: now}
@@ -168,27 +464,17 @@ func (m *Middleware) RateLimitMiddleware(next http.Handler) http.Handler {
 			c.lastReset = now
 		}
 
-		if c.count >= 100 {
+		limited := c.count >= 100
+		if !limited {
+			c.count++
+		}
+		mu.Unlock()
+
+		if limited {
+			m.metrics.RateLimited()
 			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 			return
 		}
-
-		c.count++
-		next.ServeHTTP(w, r)
-	})
-}
-
-func (m *Middleware) SecurityHeadersMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.Header().Set("X-Frame-Options", "DENY")
-		w.Header().Set("X-XSS-Protection", "1; mode=block")
-		w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-		w.Header().Set("Content-Security-Policy", "default-src 'self'")
-		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
-		w.Header().Set("Cache-Control", "no-store, max-age=0")
-		w.Header().Set("Pragma", "no-cache")
-
 		next.ServeHTTP(w, r)
 	})
 }
@@ -199,15 +485,7 @@ func (m *Middleware) SessionRefreshMiddleware(next http.Handler) http.Handler {
 		if err == nil {
 			err = m.sessionService.RefreshSession(r.Context(), cookie.Value)
 			if err == nil {
-				http.SetCookie(w, &http.Cookie{
-					Name:     "session",
-					Value:    cookie.Value,
-					Path:     "/",
-					HttpOnly: true,
-					Secure:   true,
-					SameSite: http.SameSiteStrictMode,
-					MaxAge:   int(24 * time.Hour.Seconds()),
-				})
+				setSessionCookie(w, cookie.Value)
 			}
 		}
 
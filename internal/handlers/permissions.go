@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+
+	"template/internal/services"
+)
+
+// permissionCache loads the permissions of the request's user on first use,
+// so that the route guards and template helpers of one request share a
+// single lookup.
+type permissionCache struct {
+	once  sync.Once
+	load  func(context.Context) (services.Permissions, error)
+	perms services.Permissions
+	err   error
+}
+
+func (c *permissionCache) get(ctx context.Context) (services.Permissions, error) {
+	c.once.Do(func() { c.perms, c.err = c.load(ctx) })
+	return c.perms, c.err
+}
+
+// permissions returns the permissions of the user authenticated by
+// AuthMiddleware; requests without a user have none.
+func permissions(ctx context.Context) (services.Permissions, error) {
+	c, ok := ctx.Value(permkey).(*permissionCache)
+	if !ok {
+		return nil, nil
+	}
+	return c.get(ctx)
+}
+
+// Can reports whether the current user holds perm. A failed lookup counts
+// as not holding it.
+func Can(ctx context.Context, perm string) bool {
+	perms, err := permissions(ctx)
+	return err == nil && perms.Has(perm)
+}
+
+// RequirePermission answers 403 unless the user authenticated by
+// AuthMiddleware holds perm. It must come after AuthMiddleware.
+func (m *Middleware) RequirePermission(perm string) Mw {
+	return requirePermission(perm, func(w http.ResponseWriter, status int) {
+		http.Error(w, http.StatusText(status), status)
+	})
+}
+
+// APIRequirePermission is RequirePermission for JSON endpoints, after
+// APIAuthMiddleware.
+func (m *Middleware) APIRequirePermission(perm string) Mw {
+	return requirePermission(perm, func(w http.ResponseWriter, status int) {
+		jsonError(w, status, http.StatusText(status))
+	})
+}
+
+func requirePermission(perm string, deny func(w http.ResponseWriter, status int)) Mw {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			perms, err := permissions(r.Context())
+			switch {
+			case err != nil:
+				log.Println(err.Error())
+				deny(w, http.StatusInternalServerError)
+			case !perms.Has(perm):
+				deny(w, http.StatusForbidden)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
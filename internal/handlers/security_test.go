@@ -0,0 +1,38 @@
+package handlers_test
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"template/internal/handlers"
+)
+
+func TestCSPReport(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"legacy", "application/csp-report", `{"csp-report":{"violated-directive":"img-src"}}`, http.StatusNoContent},
+		{"reporting api", "application/reports+json", `[{"type":"csp-violation"}]`, http.StatusNoContent},
+		{"malformed", "application/csp-report", `{"other":1}`, http.StatusBadRequest},
+		{"too large", "application/reports+json", "[" + strings.Repeat(`{},`, 64<<10) + "{}]", http.StatusRequestEntityTooLarge},
+	}
+	h := handlers.CSPReport(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,32 @@
 package handlers
 
 import (
+	"net/http"
+	"time"
+
+	"template/internal/metrics"
 	"template/internal/services"
 )
 
 type UserHandler struct {
 	US *services.UserService
 	SS *services.SessionService
+	IS *services.ImpersonationService
+	RS *services.RoleService
+	AS *services.AuditService
+	M  *metrics.Metrics
+	// Google signs users in with their Google account; nil disables it.
+	Google *GoogleOAuth
+
+	// DeletionGrace is how long the accounts users delete can be restored.
+	DeletionGrace time.Duration
+	// MaxAvatarBytes is the largest avatar upload, enforced by its route.
+	MaxAvatarBytes int64
+}
+
+// Dashboard is the landing page of signed-in users.
+func (uh *UserHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
+	if err := render(w, r, "pages/dashboard.html", CurrentUser(r.Context())); err != nil {
+		internal(w, err)
+	}
 }
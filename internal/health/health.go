@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check reports whether a dependency is usable. It should return quickly
+// and honour ctx cancellation.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker serves liveness and readiness probes. Readiness runs every
+// registered Check and fails as soon as shutdown begins, so that load
+// balancers stop routing to the instance while it drains.
+type Checker struct {
+	timeout time.Duration
+	logger  *slog.Logger
+
+	mu     sync.RWMutex
+	checks []namedCheck
+
+	shuttingDown atomic.Bool
+}
+
+func NewChecker(timeout time.Duration, logger *slog.Logger) *Checker {
+	return &Checker{timeout: timeout, logger: logger}
+}
+
+// Register adds a readiness check reported under name.
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Shutdown marks the instance as not ready. It cannot be undone.
+func (c *Checker) Shutdown() {
+	c.shuttingDown.Store(true)
+}
+
+type report struct {
+	Status string `json:"status"`
+}
+
+// Liveness only reports that the process is serving requests.
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, report{Status: "ok"})
+}
+
+// Readiness runs the registered checks concurrently and answers 503 if
+// any of them fails or shutdown has begun. Failures are logged rather
+// than returned, since the endpoint is public.
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	if c.shuttingDown.Load() {
+		writeReport(w, http.StatusServiceUnavailable, report{Status: "shutting down"})
+		return
+	}
+
+	c.mu.RLock()
+	checks := c.checks
+	c.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), c.timeout)
+	defer cancel()
+
+	results := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = nc.check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	rep := report{Status: "ok"}
+	code := http.StatusOK
+	for i, nc := range checks {
+		if err := results[i]; err != nil {
+			c.logger.WarnContext(r.Context(), "readiness check failed",
+				slog.String("check", nc.name),
+				slog.String("error", err.Error()),
+			)
+			rep.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeReport(w, code, rep)
+}
+
+func writeReport(w http.ResponseWriter, code int, rep report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,46 @@
+package health_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"template/internal/health"
+)
+
+func TestReadiness(t *testing.T) {
+	var logs bytes.Buffer
+	hc := health.NewChecker(time.Second, slog.New(slog.NewTextHandler(&logs, nil)))
+	hc.Register("database", func(context.Context) error { return nil })
+
+	readiness := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		hc.Readiness(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w
+	}
+
+	if w := readiness(); w.Code != http.StatusOK || w.Body.String() != "{\"status\":\"ok\"}\n" {
+		t.Errorf("Readiness = %d %q, want 200 ok", w.Code, w.Body)
+	}
+
+	hc.Register("migrations", func(context.Context) error { return errors.New("database at version 3, want 4") })
+	w := readiness()
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "{\"status\":\"unavailable\"}\n" {
+		t.Errorf("Readiness = %d %q, want 503 unavailable", w.Code, w.Body)
+	}
+	// The details go to the log, not to the client.
+	if !strings.Contains(logs.String(), "check=migrations") || !strings.Contains(logs.String(), "version 3") {
+		t.Errorf("failure not logged: %s", logs.String())
+	}
+
+	hc.Shutdown()
+	if w := readiness(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Readiness after Shutdown = %d, want 503", w.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type stage struct {
+	name string
+	stop func(context.Context) error
+}
+
+// Shutdown stops the application's components in the reverse order they
+// were added, like deferred calls: a component added after the database
+// is stopped before it. All stages share the deadline of the context
+// passed to Run.
+type Shutdown struct {
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	stages []stage
+}
+
+func NewShutdown(logger *slog.Logger) *Shutdown {
+	return &Shutdown{logger: logger}
+}
+
+// Add registers stop to be run by Run under name.
+func (s *Shutdown) Add(name string, stop func(context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stages = append(s.stages, stage{name: name, stop: stop})
+}
+
+// Run stops every stage, continuing past failures, and returns their
+// joined errors. Later calls do nothing.
+func (s *Shutdown) Run(ctx context.Context) error {
+	s.mu.Lock()
+	stages := s.stages
+	s.stages = nil
+	s.mu.Unlock()
+
+	var errs []error
+	for i := len(stages) - 1; i >= 0; i-- {
+		st := stages[i]
+		s.logger.Info("stopping", slog.String("component", st.name))
+		if err := st.stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", st.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Workers runs background goroutines until Stop cancels their context.
+type Workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewWorkers() *Workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Workers{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in a goroutine. fn must return once ctx is cancelled.
+func (w *Workers) Go(fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(w.ctx)
+	}()
+}
+
+// Every runs fn in a goroutine once straight away and then every interval,
+// until the workers are stopped. A run in progress is not interrupted by
+// the next tick, which is skipped instead.
+func (w *Workers) Every(interval time.Duration, fn func(ctx context.Context)) {
+	w.Go(func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			fn(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+// Stop cancels the workers and waits for them to return, or for ctx to expire.
+func (w *Workers) Stop(ctx context.Context) error {
+	w.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "app"
+
+// Metrics holds every collector exposed on /metrics.
+// A nil *Metrics is valid and records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+
+	logins             *prometheus.CounterVec
+	loginFailures      *prometheus.CounterVec
+	registrations      *prometheus.CounterVec
+	sessionValidations *prometheus.CounterVec
+	rateLimited        prometheus.Counter
+}
+
+func New(conn *sql.DB) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests by method, route pattern and status code.",
+		}, []string{"method", "route", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency by method and route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_in_flight",
+			Help:      "HTTP requests currently being served.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "logins_total",
+			Help:      "Successful logins by method.",
+		}, []string{"method"}),
+		loginFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "login_failures_total",
+			Help:      "Failed logins by reason.",
+		}, []string{"reason"}),
+		registrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "registrations_total",
+			Help:      "Accounts created by method.",
+		}, []string{"method"}),
+		sessionValidations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "session_validations_total",
+			Help:      "Session cookie validations by result.",
+		}, []string{"result"}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "rate_limited_total",
+			Help:      "Requests rejected by the rate limiter.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(conn, "postgres"),
+		m.requests,
+		m.duration,
+		m.inFlight,
+		m.logins,
+		m.loginFailures,
+		m.registrations,
+		m.sessionValidations,
+		m.rateLimited,
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus text exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) RequestStarted() {
+	if m == nil {
+		return
+	}
+	m.inFlight.Inc()
+}
+
+func (m *Metrics) RequestFinished(method, route, code string, seconds float64) {
+	if m == nil {
+		return
+	}
+	m.inFlight.Dec()
+	m.requests.WithLabelValues(method, route, code).Inc()
+	m.duration.WithLabelValues(method, route).Observe(seconds)
+}
+
+func (m *Metrics) LoginSucceeded(method string) {
+	if m == nil {
+		return
+	}
+	m.logins.WithLabelValues(method).Inc()
+}
+
+func (m *Metrics) LoginFailed(reason string) {
+	if m == nil {
+		return
+	}
+	m.loginFailures.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) Registered(method string) {
+	if m == nil {
+		return
+	}
+	m.registrations.WithLabelValues(method).Inc()
+}
+
+func (m *Metrics) SessionValidated(result string) {
+	if m == nil {
+		return
+	}
+	m.sessionValidations.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) RateLimited() {
+	if m == nil {
+		return
+	}
+	m.rateLimited.Inc()
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// AuditEvent is one entry of the audit trail. Entries are never changed
+// once written.
+type AuditEvent struct {
+	ID   int64
+	Type string
+	// ActorID is the user who acted, if any; TargetUserID the user acted
+	// upon. Either may name a user who has since been deleted.
+	ActorID      sql.NullString
+	TargetUserID sql.NullString
+	IPAddress    net.IP
+	UserAgent    string
+	RequestID    string
+	Metadata     map[string]string
+	CreatedAt    time.Time
+}
+
+// AuditFilter narrows ListAuditEvents. Zero fields do not filter.
+type AuditFilter struct {
+	ActorID      string
+	TargetUserID string
+	Type         string
+	// Before, when set, keeps only events with a smaller ID, so that the
+	// ID of the last event of one page fetches the next.
+	Before int64
+	// Limit caps the number of events; it must be positive.
+	Limit int
+}
+
+type AuditRepository struct {
+	DB Querier
+}
+
+// RecordAuditEvent appends e to the trail, setting its ID and CreatedAt.
+func (ar *AuditRepository) RecordAuditEvent(ctx context.Context, e *AuditEvent) (err error) {
+	ctx, span := startSpan(ctx, "AuditRepository.RecordAuditEvent", "audit_events")
+	defer func() { endSpan(span, err) }()
+
+	metadata := []byte("{}")
+	if e.Metadata != nil {
+		if metadata, err = json.Marshal(e.Metadata); err != nil {
+			return err
+		}
+	}
+	return ar.DB.QueryRowContext(ctx, `
+        INSERT INTO audit_events (type, actor_id, target_user_id, ip_address, user_agent, request_id, metadata)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id, created_at
+    `, e.Type, e.ActorID, e.TargetUserID, ipAddress(e.IPAddress), e.UserAgent, e.RequestID, metadata).Scan(&e.ID, &e.CreatedAt)
+}
+
+// ListAuditEvents returns the matching events, newest first.
+func (ar *AuditRepository) ListAuditEvents(ctx context.Context, f AuditFilter) (_ []AuditEvent, err error) {
+	ctx, span := startSpan(ctx, "AuditRepository.ListAuditEvents", "audit_events")
+	defer func() { endSpan(span, err) }()
+
+	if f.Limit <= 0 {
+		return nil, fmt.Errorf("invalid limit %d", f.Limit)
+	}
+	var q query
+	if f.ActorID != "" {
+		q.add("actor_id = " + q.arg(f.ActorID))
+	}
+	if f.TargetUserID != "" {
+		q.add("target_user_id = " + q.arg(f.TargetUserID))
+	}
+	if f.Type != "" {
+		q.add("type = " + q.arg(f.Type))
+	}
+	if f.Before > 0 {
+		q.add("id < " + q.arg(f.Before))
+	}
+	rows, err := ar.DB.QueryContext(ctx, `
+        SELECT id, type, actor_id, target_user_id, host(ip_address), user_agent, request_id, metadata, created_at
+        FROM audit_events`+q.where()+`
+        ORDER BY id DESC
+        LIMIT `+q.arg(f.Limit), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		var ip sql.NullString
+		var metadata []byte
+		if err := rows.Scan(&e.ID, &e.Type, &e.ActorID, &e.TargetUserID, &ip, &e.UserAgent, &e.RequestID, &metadata, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.IPAddress = net.ParseIP(ip.String)
+		if err := json.Unmarshal(metadata, &e.Metadata); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// DeleteAuditEventsBefore deletes the events created before t and reports
+// how many there were. It is the only way events leave the trail: the
+// table's trigger rejects any delete outside a transaction that has set
+// audit.purging, which this does.
+func (ar *AuditRepository) DeleteAuditEventsBefore(ctx context.Context, t time.Time) (n int64, err error) {
+	ctx, span := startSpan(ctx, "AuditRepository.DeleteAuditEventsBefore", "audit_events")
+	defer func() { endSpan(span, err) }()
+
+	purge := func(q Querier) error {
+		if _, err := q.ExecContext(ctx, `SET LOCAL audit.purging = 'on'`); err != nil {
+			return err
+		}
+		res, err := q.ExecContext(ctx, `DELETE FROM audit_events WHERE created_at < $1`, t)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	}
+	// SET LOCAL only lasts until the end of the transaction, so outside
+	// one both statements need a transaction of their own.
+	if db, ok := ar.DB.(*sql.DB); ok {
+		err = WithTx(ctx, db, func(tx *sql.Tx) error { return purge(tx) })
+	} else {
+		err = purge(ar.DB)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
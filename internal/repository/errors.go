@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ErrUniqueViolation is returned when a write would duplicate the value of a
+// unique column, such as a user's email.
+var ErrUniqueViolation = errors.New("unique constraint violated")
+
+// ErrForeignKeyViolation is returned when a write refers to a row that does
+// not exist, such as a role assigned to an unknown user.
+var ErrForeignKeyViolation = errors.New("foreign key constraint violated")
+
+// mapError turns the driver errors callers act on into the sentinels above,
+// keeping the constraint name in the message.
+func mapError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch pqErr.Code {
+	case "23505":
+		return fmt.Errorf("%w: %s", ErrUniqueViolation, pqErr.Constraint)
+	case "23503":
+		return fmt.Errorf("%w: %s", ErrForeignKeyViolation, pqErr.Constraint)
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"time"
+)
+
+// Impersonation is a session in which an admin sees the app as another
+// user. It is separate from both users' own sessions and always expires.
+type Impersonation struct {
+	ID           string
+	AdminID      string
+	TargetUserID string
+	CookieHash   string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	IPAddress    net.IP
+	UserAgent    string
+}
+
+type ImpersonationRepository struct {
+	DB Querier
+}
+
+// CreateImpersonation stores imp, setting its ID and CreatedAt. It fails
+// with ErrForeignKeyViolation when either user does not exist.
+func (ir *ImpersonationRepository) CreateImpersonation(ctx context.Context, imp *Impersonation) (err error) {
+	ctx, span := startSpan(ctx, "ImpersonationRepository.CreateImpersonation", "impersonations")
+	defer func() { endSpan(span, err) }()
+
+	err = ir.DB.QueryRowContext(ctx, `
+        INSERT INTO impersonations (admin_id, target_user_id, cookie_hash, expires_at, ip_address, user_agent)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at
+    `, imp.AdminID, imp.TargetUserID, imp.CookieHash, imp.ExpiresAt, ipAddress(imp.IPAddress), imp.UserAgent).Scan(&imp.ID, &imp.CreatedAt)
+	return mapError(err)
+}
+
+// GetImpersonation returns the impersonation with the given cookie hash,
+// or nil if there is none.
+func (ir *ImpersonationRepository) GetImpersonation(ctx context.Context, cookieHash string) (_ *Impersonation, err error) {
+	ctx, span := startSpan(ctx, "ImpersonationRepository.GetImpersonation", "impersonations")
+	defer func() { endSpan(span, err) }()
+
+	var imp Impersonation
+	var ip sql.NullString
+	err = ir.DB.QueryRowContext(ctx, `
+        SELECT id, admin_id, target_user_id, cookie_hash, created_at, expires_at, host(ip_address), user_agent
+        FROM impersonations
+        WHERE cookie_hash = $1
+    `, cookieHash).Scan(&imp.ID, &imp.AdminID, &imp.TargetUserID, &imp.CookieHash, &imp.CreatedAt, &imp.ExpiresAt, &ip, &imp.UserAgent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	imp.IPAddress = net.ParseIP(ip.String)
+	return &imp, nil
+}
+
+func (ir *ImpersonationRepository) DeleteImpersonation(ctx context.Context, cookieHash string) (err error) {
+	ctx, span := startSpan(ctx, "ImpersonationRepository.DeleteImpersonation", "impersonations")
+	defer func() { endSpan(span, err) }()
+
+	_, err = ir.DB.ExecContext(ctx, `DELETE FROM impersonations WHERE cookie_hash = $1`, cookieHash)
+	return err
+}
+
+// DeleteExpiredImpersonations removes the impersonations whose expiry has
+// passed and reports how many there were.
+func (ir *ImpersonationRepository) DeleteExpiredImpersonations(ctx context.Context) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "ImpersonationRepository.DeleteExpiredImpersonations", "impersonations")
+	defer func() { endSpan(span, err) }()
+
+	res, err := ir.DB.ExecContext(ctx, `DELETE FROM impersonations WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,613 @@
+// Package memory implements the user, session, role and audit stores in memory, with
+// the same observable behaviour as the Postgres repositories, for tests.
+package memory
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"template/internal/repository"
+	"template/internal/services"
+
+	"github.com/google/uuid"
+)
+
+// Store holds the tables shared by the repositories, so that deleting a
+// user cascades to its session and roles as the foreign keys do.
+type Store struct {
+	txMu sync.Mutex // serialises WithTx
+
+	mu       sync.Mutex
+	users    map[string]repository.User
+	sessions map[string]repository.Session // by cookie hash
+	// impersonations are by cookie hash.
+	impersonations map[string]repository.Impersonation
+	roles          map[string]repository.Role
+	userRoles      map[userRole]struct{}
+	events         []repository.AuditEvent
+	// lastEventID, like a Postgres sequence, survives rolled back
+	// transactions and purges.
+	lastEventID int64
+}
+
+type userRole struct {
+	userID, role string
+}
+
+// NewStore returns an empty store holding the roles the migrations seed.
+func NewStore() *Store {
+	return &Store{
+		users:          make(map[string]repository.User),
+		sessions:       make(map[string]repository.Session),
+		impersonations: make(map[string]repository.Impersonation),
+		roles: map[string]repository.Role{
+			repository.RoleAdmin: {
+				Name:        repository.RoleAdmin,
+				Description: "Full access to the back office",
+				Permissions: []string{repository.PermAuditRead, repository.PermUsersImpersonate, repository.PermUsersRead, repository.PermUsersWrite},
+			},
+		},
+		userRoles: make(map[userRole]struct{}),
+	}
+}
+
+// Users returns a user store backed by s.
+func (s *Store) Users() *UserRepo {
+	return &UserRepo{s: s}
+}
+
+// Sessions returns a session store backed by s.
+func (s *Store) Sessions() *SessionRepository {
+	return &SessionRepository{s: s}
+}
+
+// Roles returns a role store backed by s.
+func (s *Store) Roles() *RoleRepository {
+	return &RoleRepository{s: s}
+}
+
+// Impersonations returns an impersonation store backed by s.
+func (s *Store) Impersonations() *ImpersonationRepository {
+	return &ImpersonationRepository{s: s}
+}
+
+// Audit returns an audit store backed by s.
+func (s *Store) Audit() *AuditRepository {
+	return &AuditRepository{s: s}
+}
+
+// WithTx runs fn over s, one unit of work at a time, and restores the
+// previous contents if it fails. Writes made outside WithTx while it runs
+// are not isolated from it.
+func (s *Store) WithTx(ctx context.Context, fn func(ctx context.Context, tx services.Stores) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.Lock()
+	users, sessions, userRoles, events := maps.Clone(s.users), maps.Clone(s.sessions), maps.Clone(s.userRoles), s.events
+	impersonations := maps.Clone(s.impersonations)
+	s.mu.Unlock()
+
+	tx := services.Stores{
+		Users:          s.Users(),
+		Sessions:       s.Sessions(),
+		Impersonations: s.Impersonations(),
+		Roles:          s.Roles(),
+		Audit:          s.Audit(),
+	}
+	if err := fn(ctx, tx); err != nil {
+		s.mu.Lock()
+		s.users, s.sessions, s.userRoles, s.events = users, sessions, userRoles, events
+		s.impersonations = impersonations
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// now matches the microsecond precision of a Postgres timestamp.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+type UserRepo struct {
+	s *Store
+}
+
+func (r *UserRepo) CreateUser(_ context.Context, u *repository.User) (*repository.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user := *u
+	user.ID = uuid.NewString()
+	if user.Status == "" {
+		user.Status = repository.StatusActive
+	}
+	if !repository.ValidStatus(user.Status) {
+		return nil, fmt.Errorf("memory: invalid status %q", user.Status)
+	}
+	user.EmailVerifiedAt.Time = user.EmailVerifiedAt.Time.Truncate(time.Microsecond)
+	user.StatusExpiresAt.Time = user.StatusExpiresAt.Time.Truncate(time.Microsecond)
+	if err := r.s.checkUnique(user); err != nil {
+		return nil, err
+	}
+	user.CreatedAt = now()
+	user.UpdatedAt = user.CreatedAt
+	r.s.users[user.ID] = user
+	return &user, nil
+}
+
+func (r *UserRepo) GetUserByID(_ context.Context, id string) (*repository.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if u, ok := r.s.users[id]; ok {
+		return &u, nil
+	}
+	return nil, nil
+}
+
+func (r *UserRepo) GetUserByEmail(_ context.Context, email string) (*repository.User, error) {
+	return r.find(func(u repository.User) bool { return u.Email == email })
+}
+
+func (r *UserRepo) GetUserByGoogleID(_ context.Context, gid string) (*repository.User, error) {
+	return r.find(func(u repository.User) bool { return u.GoogleID.Valid && u.GoogleID.String == gid })
+}
+
+func (r *UserRepo) ListUsers(_ context.Context, opts repository.ListUsersOptions) (*repository.UserPage, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var users []*repository.User
+	for _, u := range r.s.users {
+		if matches(u, opts.Filter) {
+			users = append(users, &u)
+		}
+	}
+	order := func(a, b *repository.User) int {
+		c := a.CreatedAt.Compare(b.CreatedAt)
+		if opts.Sort == repository.SortEmail {
+			c = cmp.Compare(a.Email, b.Email)
+		}
+		c = cmp.Or(c, cmp.Compare(a.ID, b.ID))
+		if opts.Desc {
+			c = -c
+		}
+		return c
+	}
+	slices.SortFunc(users, order)
+
+	page := &repository.UserPage{Total: len(users)}
+	if opts.After != "" {
+		c, _ := repository.DecodeUserCursor(opts.After, opts.Sort)
+		last := &repository.User{ID: c.ID, Email: c.Key}
+		last.CreatedAt, _ = time.Parse(time.RFC3339Nano, c.Key)
+		i := slices.IndexFunc(users, func(u *repository.User) bool { return order(u, last) > 0 })
+		if i < 0 {
+			i = len(users)
+		}
+		users = users[i:]
+	}
+	if len(users) > opts.Limit {
+		users = users[:opts.Limit]
+		page.Next = repository.CursorAfter(users[opts.Limit-1], opts.Sort).Encode()
+	}
+	page.Users = users
+	return page, nil
+}
+
+func (r *UserRepo) UpdateUser(_ context.Context, u *repository.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	old, ok := r.s.users[u.ID]
+	if !ok {
+		return nil
+	}
+	user := *u
+	user.EmailVerifiedAt.Time = user.EmailVerifiedAt.Time.Truncate(time.Microsecond)
+	user.StatusExpiresAt.Time = user.StatusExpiresAt.Time.Truncate(time.Microsecond)
+	if !repository.ValidStatus(user.Status) {
+		return fmt.Errorf("memory: invalid status %q", user.Status)
+	}
+	if err := r.s.checkUnique(user); err != nil {
+		return err
+	}
+	user.CreatedAt = old.CreatedAt
+	user.UpdatedAt = now()
+	r.s.users[user.ID] = user
+	return nil
+}
+
+func (r *UserRepo) DeleteUser(_ context.Context, id string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.users, id)
+	for hash, sess := range r.s.sessions {
+		if sess.UserID == id {
+			delete(r.s.sessions, hash)
+		}
+	}
+	for ur := range r.s.userRoles {
+		if ur.userID == id {
+			delete(r.s.userRoles, ur)
+		}
+	}
+	for hash, imp := range r.s.impersonations {
+		if imp.AdminID == id || imp.TargetUserID == id {
+			delete(r.s.impersonations, hash)
+		}
+	}
+	return nil
+}
+
+func (r *UserRepo) GetUserBySessionID(_ context.Context, sid string) (*repository.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, sess := range r.s.sessions {
+		if sess.ID == sid {
+			if u, ok := r.s.users[sess.UserID]; ok {
+				return &u, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (r *UserRepo) find(match func(repository.User) bool) (*repository.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, u := range r.s.users {
+		if match(u) {
+			return &u, nil
+		}
+	}
+	return nil, nil
+}
+
+func matches(u repository.User, f repository.UserFilter) bool {
+	switch {
+	case f.Email != "" && !strings.Contains(strings.ToLower(u.Email), strings.ToLower(f.Email)),
+		f.Provider == repository.ProviderPassword && !u.PasswordHash.Valid,
+		f.Provider == repository.ProviderGoogle && !u.GoogleID.Valid,
+		f.Verified != nil && *f.Verified != u.EmailVerifiedAt.Valid,
+		f.Status != "" && u.Status != f.Status,
+		!f.CreatedFrom.IsZero() && u.CreatedAt.Before(f.CreatedFrom),
+		!f.CreatedTo.IsZero() && !u.CreatedAt.Before(f.CreatedTo):
+		return false
+	}
+	return true
+}
+
+// checkUnique enforces the unique email and google_id columns against every
+// other user. The caller holds the lock.
+func (s *Store) checkUnique(u repository.User) error {
+	for id, other := range s.users {
+		if id == u.ID {
+			continue
+		}
+		if other.Email == u.Email {
+			return fmt.Errorf("%w: users_email_key", repository.ErrUniqueViolation)
+		}
+		if u.GoogleID.Valid && other.GoogleID.Valid && other.GoogleID.String == u.GoogleID.String {
+			return fmt.Errorf("%w: users_google_id_key", repository.ErrUniqueViolation)
+		}
+	}
+	return nil
+}
+
+type SessionRepository struct {
+	s *Store
+}
+
+// Create stores s as the user's only session, replacing any previous one,
+// and fails when the user does not exist, as the foreign key would.
+func (r *SessionRepository) Create(_ context.Context, s repository.Session) (string, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[s.UserID]; !ok {
+		return "", fmt.Errorf("%w: sessions_user_id_fkey", repository.ErrForeignKeyViolation)
+	}
+	if old, ok := r.s.sessions[s.CookieHash]; ok && old.UserID != s.UserID {
+		return "", fmt.Errorf("%w: sessions_cookie_hash_key", repository.ErrUniqueViolation)
+	}
+	s.ID = uuid.NewString()
+	for hash, old := range r.s.sessions {
+		if old.UserID == s.UserID {
+			s.ID = old.ID
+			delete(r.s.sessions, hash)
+		}
+	}
+	s.CreatedAt = now()
+	s.ExpiresAt = copyTime(s.ExpiresAt)
+	s.IPAddress = slices.Clone(s.IPAddress)
+	r.s.sessions[s.CookieHash] = s
+	return s.CookieHash, nil
+}
+
+func (r *SessionRepository) GetByCookieHash(_ context.Context, cookieHash string) (repository.Session, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	s, ok := r.s.sessions[cookieHash]
+	if !ok {
+		return repository.Session{}, sql.ErrNoRows
+	}
+	s.ExpiresAt = copyTime(s.ExpiresAt)
+	s.IPAddress = slices.Clone(s.IPAddress)
+	return s, nil
+}
+
+func (r *SessionRepository) DeleteByCookieHash(_ context.Context, cookieHash string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.sessions, cookieHash)
+	return nil
+}
+
+func (r *SessionRepository) UpdateExpiry(_ context.Context, cookieHash string, expiresAt time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if s, ok := r.s.sessions[cookieHash]; ok {
+		s.ExpiresAt = copyTime(&expiresAt)
+		r.s.sessions[cookieHash] = s
+	}
+	return nil
+}
+
+func (r *SessionRepository) ListByUserID(_ context.Context, userID string) ([]repository.Session, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var sessions []repository.Session
+	for _, s := range r.s.sessions {
+		if s.UserID == userID {
+			s.ExpiresAt = copyTime(s.ExpiresAt)
+			s.IPAddress = slices.Clone(s.IPAddress)
+			sessions = append(sessions, s)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b repository.Session) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return sessions, nil
+}
+
+func (r *SessionRepository) DeleteByUserID(_ context.Context, userID string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for hash, s := range r.s.sessions {
+		if s.UserID == userID {
+			delete(r.s.sessions, hash)
+		}
+	}
+	return nil
+}
+
+// DeleteExpired removes sessions whose expiry has passed; sessions without
+// an expiry are kept.
+func (r *SessionRepository) DeleteExpired(_ context.Context) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var n int64
+	t := time.Now()
+	for hash, s := range r.s.sessions {
+		if s.ExpiresAt != nil && s.ExpiresAt.Before(t) {
+			delete(r.s.sessions, hash)
+			n++
+		}
+	}
+	return n, nil
+}
+
+type RoleRepository struct {
+	s *Store
+}
+
+func (r *RoleRepository) ListRoles(_ context.Context) ([]repository.Role, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var roles []repository.Role
+	for _, role := range r.s.roles {
+		role.Permissions = slices.Sorted(slices.Values(role.Permissions))
+		roles = append(roles, role)
+	}
+	slices.SortFunc(roles, func(a, b repository.Role) int { return cmp.Compare(a.Name, b.Name) })
+	return roles, nil
+}
+
+func (r *RoleRepository) UserRoles(_ context.Context, userID string) ([]string, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var names []string
+	for ur := range r.s.userRoles {
+		if ur.userID == userID {
+			names = append(names, ur.role)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (r *RoleRepository) UserPermissions(_ context.Context, userID string) ([]string, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var perms []string
+	for ur := range r.s.userRoles {
+		if ur.userID == userID {
+			perms = append(perms, r.s.roles[ur.role].Permissions...)
+		}
+	}
+	slices.Sort(perms)
+	return slices.Compact(perms), nil
+}
+
+func (r *RoleRepository) AssignRole(_ context.Context, userID, role string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.users[userID]; !ok {
+		return fmt.Errorf("%w: user_roles_user_id_fkey", repository.ErrForeignKeyViolation)
+	}
+	if _, ok := r.s.roles[role]; !ok {
+		return fmt.Errorf("%w: user_roles_role_fkey", repository.ErrForeignKeyViolation)
+	}
+	r.s.userRoles[userRole{userID, role}] = struct{}{}
+	return nil
+}
+
+func (r *RoleRepository) UnassignRole(_ context.Context, userID, role string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.userRoles, userRole{userID, role})
+	return nil
+}
+
+type ImpersonationRepository struct {
+	s *Store
+}
+
+func (r *ImpersonationRepository) CreateImpersonation(_ context.Context, imp *repository.Impersonation) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, id := range []string{imp.AdminID, imp.TargetUserID} {
+		if _, ok := r.s.users[id]; !ok {
+			return fmt.Errorf("%w: impersonations_user_fkey", repository.ErrForeignKeyViolation)
+		}
+	}
+	if _, ok := r.s.impersonations[imp.CookieHash]; ok {
+		return fmt.Errorf("%w: impersonations_cookie_hash_key", repository.ErrUniqueViolation)
+	}
+	imp.ID = uuid.NewString()
+	imp.CreatedAt = now()
+	stored := *imp
+	stored.ExpiresAt = stored.ExpiresAt.Truncate(time.Microsecond)
+	stored.IPAddress = slices.Clone(imp.IPAddress)
+	r.s.impersonations[imp.CookieHash] = stored
+	return nil
+}
+
+func (r *ImpersonationRepository) GetImpersonation(_ context.Context, cookieHash string) (*repository.Impersonation, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	imp, ok := r.s.impersonations[cookieHash]
+	if !ok {
+		return nil, nil
+	}
+	imp.IPAddress = slices.Clone(imp.IPAddress)
+	return &imp, nil
+}
+
+func (r *ImpersonationRepository) DeleteImpersonation(_ context.Context, cookieHash string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.impersonations, cookieHash)
+	return nil
+}
+
+func (r *ImpersonationRepository) DeleteExpiredImpersonations(_ context.Context) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var n int64
+	t := time.Now()
+	for hash, imp := range r.s.impersonations {
+		if imp.ExpiresAt.Before(t) {
+			delete(r.s.impersonations, hash)
+			n++
+		}
+	}
+	return n, nil
+}
+
+type AuditRepository struct {
+	s *Store
+}
+
+func (r *AuditRepository) RecordAuditEvent(_ context.Context, e *repository.AuditEvent) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.lastEventID++
+	e.ID = r.s.lastEventID
+	e.CreatedAt = now()
+	stored := *e
+	stored.IPAddress = slices.Clone(e.IPAddress)
+	stored.Metadata = maps.Clone(e.Metadata)
+	r.s.events = append(r.s.events, stored)
+	return nil
+}
+
+func (r *AuditRepository) ListAuditEvents(_ context.Context, f repository.AuditFilter) ([]repository.AuditEvent, error) {
+	if f.Limit <= 0 {
+		return nil, fmt.Errorf("invalid limit %d", f.Limit)
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var events []repository.AuditEvent
+	for _, e := range slices.Backward(r.s.events) {
+		if f.ActorID != "" && e.ActorID.String != f.ActorID ||
+			f.TargetUserID != "" && e.TargetUserID.String != f.TargetUserID ||
+			f.Type != "" && e.Type != f.Type ||
+			f.Before > 0 && e.ID >= f.Before {
+			continue
+		}
+		e.IPAddress = slices.Clone(e.IPAddress)
+		e.Metadata = maps.Clone(e.Metadata)
+		if e.Metadata == nil {
+			e.Metadata = map[string]string{}
+		}
+		events = append(events, e)
+		if len(events) == f.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+func (r *AuditRepository) DeleteAuditEventsBefore(_ context.Context, t time.Time) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	n := len(r.s.events)
+	r.s.events = slices.DeleteFunc(slices.Clone(r.s.events), func(e repository.AuditEvent) bool {
+		return e.CreatedAt.Before(t)
+	})
+	return int64(n - len(r.s.events)), nil
+}
+
+// copyTime copies t at the precision Postgres stores.
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := t.Truncate(time.Microsecond)
+	return &c
+}
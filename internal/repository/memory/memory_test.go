@@ -0,0 +1,15 @@
+package memory_test
+
+import (
+	"testing"
+
+	"template/internal/repository/memory"
+	"template/internal/repository/repotest"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Stores {
+		s := memory.NewStore()
+		return repotest.Stores{Users: s.Users(), Sessions: s.Sessions(), Impersonations: s.Impersonations(), Roles: s.Roles(), Audit: s.Audit(), Tx: s}
+	})
+}
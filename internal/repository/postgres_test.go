@@ -0,0 +1,28 @@
+package repository_test
+
+import (
+	"log/slog"
+	"testing"
+
+	"template/internal/repository"
+	"template/internal/repository/repotest"
+	"template/internal/services"
+	"template/internal/testutil"
+)
+
+// TestConformance runs the store suite against the Postgres named by
+// TEST_DATABASE_URL, each subtest in a schema of its own.
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repotest.Stores {
+		conn := testutil.PostgresSchema(t)
+		logger := slog.New(slog.DiscardHandler)
+		return repotest.Stores{
+			Users:          repository.NewUserRepo(conn, logger),
+			Sessions:       &repository.SessionRepository{DB: conn},
+			Impersonations: &repository.ImpersonationRepository{DB: conn},
+			Roles:          &repository.RoleRepository{DB: conn},
+			Audit:          &repository.AuditRepository{DB: conn},
+			Tx:             services.SQLTransactor{DB: conn, Logger: logger},
+		}
+	})
+}
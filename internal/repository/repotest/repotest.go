@@ -0,0 +1,766 @@
+// Package repotest is the conformance suite for implementations of
+// services.UserStore and services.SessionStore. Every implementation runs
+// it, so the in-memory fakes behave like Postgres wherever the services
+// can tell the difference.
+package repotest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"template/internal/repository"
+	"template/internal/services"
+
+	"github.com/google/uuid"
+)
+
+// Stores is one implementation of the stores over shared, empty tables,
+// with the Transactor that runs units of work over them.
+type Stores struct {
+	Users          services.UserStore
+	Sessions       services.SessionStore
+	Impersonations services.ImpersonationStore
+	Roles          services.RoleStore
+	Audit          services.AuditStore
+	Tx             services.Transactor
+}
+
+// Run runs the suite. open is called once per subtest and must return
+// stores with no users or sessions.
+func Run(t *testing.T, open func(t *testing.T) Stores) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Stores)
+	}{
+		{"CreateUser", testCreateUser},
+		{"UniqueEmail", testUniqueEmail},
+		{"UniqueGoogleID", testUniqueGoogleID},
+		{"LookupMissing", testLookupMissing},
+		{"ListUsersPages", testListUsersPages},
+		{"ListUsersFilters", testListUsersFilters},
+		{"UpdateUser", testUpdateUser},
+		{"DeleteUserCascades", testDeleteUserCascades},
+		{"Session", testSession},
+		{"SessionReplaced", testSessionReplaced},
+		{"SessionUnknownUser", testSessionUnknownUser},
+		{"UpdateExpiry", testUpdateExpiry},
+		{"DeleteSessions", testDeleteSessions},
+		{"DeleteExpired", testDeleteExpired},
+		{"Impersonation", testImpersonation},
+		{"SeededRoles", testSeededRoles},
+		{"AssignRole", testAssignRole},
+		{"AssignRoleUnknown", testAssignRoleUnknown},
+		{"AuditEvents", testAuditEvents},
+		{"DeleteAuditEvents", testDeleteAuditEvents},
+		{"TxCommit", testTxCommit},
+		{"TxRollback", testTxRollback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, open(t))
+		})
+	}
+}
+
+func testCreateUser(t *testing.T, s Stores) {
+	ctx := t.Context()
+	before := time.Now().Add(-time.Second)
+	u := mustCreate(t, s, &repository.User{
+		Email:        "ada@example.com",
+		PasswordHash: sql.NullString{String: "hash", Valid: true},
+		DisplayName:  "Ada",
+		Locale:       "en",
+	})
+
+	if _, err := uuid.Parse(u.ID); err != nil {
+		t.Errorf("ID = %q, want a UUID", u.ID)
+	}
+	if u.Status != repository.StatusActive {
+		t.Errorf("Status = %q, want %q", u.Status, repository.StatusActive)
+	}
+	if u.CreatedAt.Before(before) || !u.UpdatedAt.Equal(u.CreatedAt) {
+		t.Errorf("CreatedAt = %v, UpdatedAt = %v, want both now", u.CreatedAt, u.UpdatedAt)
+	}
+
+	for name, get := range map[string]func() (*repository.User, error){
+		"GetUserByID":    func() (*repository.User, error) { return s.Users.GetUserByID(ctx, u.ID) },
+		"GetUserByEmail": func() (*repository.User, error) { return s.Users.GetUserByEmail(ctx, u.Email) },
+	} {
+		got, err := get()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		assertUser(t, name, got, u)
+	}
+}
+
+func testUniqueEmail(t *testing.T, s Stores) {
+	mustCreate(t, s, &repository.User{Email: "ada@example.com"})
+	_, err := s.Users.CreateUser(t.Context(), &repository.User{Email: "ada@example.com"})
+	if !errors.Is(err, repository.ErrUniqueViolation) {
+		t.Fatalf("duplicate CreateUser error = %v, want ErrUniqueViolation", err)
+	}
+
+	other := mustCreate(t, s, &repository.User{Email: "grace@example.com"})
+	other.Email = "ada@example.com"
+	if err := s.Users.UpdateUser(t.Context(), other); !errors.Is(err, repository.ErrUniqueViolation) {
+		t.Fatalf("duplicate UpdateUser error = %v, want ErrUniqueViolation", err)
+	}
+}
+
+func testUniqueGoogleID(t *testing.T, s Stores) {
+	gid := sql.NullString{String: "g-1", Valid: true}
+	mustCreate(t, s, &repository.User{Email: "ada@example.com", GoogleID: gid})
+	// Any number of users may have no Google ID.
+	mustCreate(t, s, &repository.User{Email: "grace@example.com"})
+	mustCreate(t, s, &repository.User{Email: "linus@example.com"})
+
+	_, err := s.Users.CreateUser(t.Context(), &repository.User{Email: "alan@example.com", GoogleID: gid})
+	if !errors.Is(err, repository.ErrUniqueViolation) {
+		t.Fatalf("duplicate google_id error = %v, want ErrUniqueViolation", err)
+	}
+
+	got, err := s.Users.GetUserByGoogleID(t.Context(), "g-1")
+	if err != nil || got == nil || got.Email != "ada@example.com" {
+		t.Fatalf("GetUserByGoogleID = %v, %v; want ada", got, err)
+	}
+}
+
+func testLookupMissing(t *testing.T, s Stores) {
+	ctx := t.Context()
+	mustCreate(t, s, &repository.User{Email: "ada@example.com"})
+
+	for name, get := range map[string]func() (*repository.User, error){
+		"GetUserByID":       func() (*repository.User, error) { return s.Users.GetUserByID(ctx, uuid.NewString()) },
+		"GetUserByEmail":    func() (*repository.User, error) { return s.Users.GetUserByEmail(ctx, "ADA@example.com") },
+		"GetUserByGoogleID": func() (*repository.User, error) { return s.Users.GetUserByGoogleID(ctx, "missing") },
+	} {
+		if got, err := get(); got != nil || err != nil {
+			t.Errorf("%s = %v, %v; want nil, nil", name, got, err)
+		}
+	}
+
+	_, err := s.Sessions.GetByCookieHash(ctx, "missing")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetByCookieHash error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testListUsersPages(t *testing.T, s Stores) {
+	page, err := s.Users.ListUsers(t.Context(), repository.ListUsersOptions{Limit: 10})
+	if err != nil || len(page.Users) != 0 || page.Total != 0 || page.Next != "" {
+		t.Fatalf("ListUsers on empty store = %+v, %v", page, err)
+	}
+
+	// Creation order differs from email order.
+	created := []string{"dora@example.com", "bob@example.com", "eve@example.com", "alice@example.com", "carl@example.com"}
+	for _, email := range created {
+		mustCreate(t, s, &repository.User{Email: email})
+		time.Sleep(time.Millisecond)
+	}
+	byEmail := slices.Sorted(slices.Values(created))
+
+	for _, tc := range []struct {
+		sort string
+		desc bool
+		want []string
+	}{
+		{repository.SortCreatedAt, false, created},
+		{repository.SortCreatedAt, true, reversed(created)},
+		{repository.SortEmail, false, byEmail},
+		{repository.SortEmail, true, reversed(byEmail)},
+	} {
+		opts := repository.ListUsersOptions{Sort: tc.sort, Desc: tc.desc, Limit: 2}
+		var got []string
+		for pages := 0; ; pages++ {
+			if pages > len(created) {
+				t.Fatalf("%s desc=%v: pagination does not end", tc.sort, tc.desc)
+			}
+			page, err := s.Users.ListUsers(t.Context(), opts)
+			if err != nil {
+				t.Fatalf("%s desc=%v: %v", tc.sort, tc.desc, err)
+			}
+			if page.Total != len(created) {
+				t.Errorf("%s desc=%v: Total = %d, want %d", tc.sort, tc.desc, page.Total, len(created))
+			}
+			for _, u := range page.Users {
+				got = append(got, u.Email)
+			}
+			if page.Next == "" {
+				break
+			}
+			opts.After = page.Next
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s desc=%v: got %v, want %v", tc.sort, tc.desc, got, tc.want)
+		}
+	}
+
+	// A cursor only fits the sort order it was produced by.
+	page, err = s.Users.ListUsers(t.Context(), repository.ListUsersOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Users.ListUsers(t.Context(), repository.ListUsersOptions{Sort: repository.SortEmail, After: page.Next, Limit: 1})
+	if !errors.Is(err, repository.ErrInvalidCursor) {
+		t.Errorf("cursor from another sort: error = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func testListUsersFilters(t *testing.T, s Stores) {
+	yes, no := true, false
+	verified := sql.NullTime{Time: time.Now(), Valid: true}
+	password := sql.NullString{String: "hash", Valid: true}
+
+	mustCreate(t, s, &repository.User{Email: "ada@example.com", PasswordHash: password, EmailVerifiedAt: verified})
+	mustCreate(t, s, &repository.User{Email: "grace@example.com", GoogleID: sql.NullString{String: "g-1", Valid: true}, Status: repository.StatusSuspended})
+	mid := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	mustCreate(t, s, &repository.User{Email: "adam@example.org", PasswordHash: password})
+
+	for _, tc := range []struct {
+		name   string
+		filter repository.UserFilter
+		want   []string
+	}{
+		{"none", repository.UserFilter{}, []string{"ada@example.com", "grace@example.com", "adam@example.org"}},
+		{"email", repository.UserFilter{Email: "ADA"}, []string{"ada@example.com", "adam@example.org"}},
+		{"email domain", repository.UserFilter{Email: ".org"}, []string{"adam@example.org"}},
+		{"email literal %", repository.UserFilter{Email: "%"}, nil},
+		{"password", repository.UserFilter{Provider: repository.ProviderPassword}, []string{"ada@example.com", "adam@example.org"}},
+		{"google", repository.UserFilter{Provider: repository.ProviderGoogle}, []string{"grace@example.com"}},
+		{"verified", repository.UserFilter{Verified: &yes}, []string{"ada@example.com"}},
+		{"unverified", repository.UserFilter{Verified: &no}, []string{"grace@example.com", "adam@example.org"}},
+		{"created from", repository.UserFilter{CreatedFrom: mid}, []string{"adam@example.org"}},
+		{"created to", repository.UserFilter{CreatedTo: mid}, []string{"ada@example.com", "grace@example.com"}},
+		{"suspended", repository.UserFilter{Status: repository.StatusSuspended}, []string{"grace@example.com"}},
+		{"combined", repository.UserFilter{Email: "ad", Verified: &no}, []string{"adam@example.org"}},
+	} {
+		page, err := s.Users.ListUsers(t.Context(), repository.ListUsersOptions{Filter: tc.filter, Limit: 10})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var got []string
+		for _, u := range page.Users {
+			got = append(got, u.Email)
+		}
+		if !slices.Equal(got, tc.want) || page.Total != len(tc.want) {
+			t.Errorf("%s: got %v (total %d), want %v", tc.name, got, page.Total, tc.want)
+		}
+	}
+}
+
+func reversed(s []string) []string {
+	r := slices.Clone(s)
+	slices.Reverse(r)
+	return r
+}
+
+func testUpdateUser(t *testing.T, s Stores) {
+	u := mustCreate(t, s, &repository.User{Email: "ada@example.com"})
+	time.Sleep(time.Millisecond)
+
+	u.Email = "ada@lovelace.dev"
+	u.PasswordHash = sql.NullString{String: "new-hash", Valid: true}
+	u.GoogleID = sql.NullString{String: "g-1", Valid: true}
+	u.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	u.Status = repository.StatusSuspended
+	u.StatusReason = "spam"
+	u.StatusExpiresAt = sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}
+	u.DisplayName = "Ada Lovelace"
+	u.AvatarURL = "https://example.com/ada.png"
+	u.AvatarKey = "ada.png"
+	u.Locale = "en-GB"
+	u.Timezone = "Europe/London"
+	if err := s.Users.UpdateUser(t.Context(), u); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Users.GetUserByID(t.Context(), u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.UpdatedAt.After(got.CreatedAt) {
+		t.Errorf("UpdatedAt = %v, want after CreatedAt %v", got.UpdatedAt, got.CreatedAt)
+	}
+	u.UpdatedAt = got.UpdatedAt
+	assertUser(t, "after update", got, u)
+
+	u.Status = "frozen"
+	if err := s.Users.UpdateUser(t.Context(), u); err == nil {
+		t.Error("UpdateUser with an unknown status succeeded")
+	}
+
+	// Updating a user that does not exist is not an error.
+	if err := s.Users.UpdateUser(t.Context(), &repository.User{ID: uuid.NewString(), Email: "x@example.com"}); err != nil {
+		t.Errorf("UpdateUser of a missing user: %v", err)
+	}
+}
+
+func testDeleteUserCascades(t *testing.T, s Stores) {
+	ctx := t.Context()
+	u := mustCreate(t, s, &repository.User{Email: "ada@example.com"})
+	hash := mustSession(t, s, u.ID, "cookie-1", nil)
+
+	if err := s.Users.DeleteUser(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Users.GetUserByID(ctx, u.ID); got != nil || err != nil {
+		t.Errorf("GetUserByID after delete = %v, %v", got, err)
+	}
+	if _, err := s.Sessions.GetByCookieHash(ctx, hash); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("session after deleting its user: error = %v, want sql.ErrNoRows", err)
+	}
+	if err := s.Users.DeleteUser(ctx, u.ID); err != nil {
+		t.Errorf("deleting a missing user: %v", err)
+	}
+}
+
+func testSession(t *testing.T, s Stores) {
+	u := mustCreate(t, s, &repository.User{Email: "ada@example.com"})
+	expires := time.Now().Add(time.Hour)
+	before := time.Now().Add(-time.Second)
+	hash, err := s.Sessions.Create(t.Context(), repository.Session{
+		UserID:     u.ID,
+		CookieHash: "cookie-1",
+		ExpiresAt:  &expires,
+		IPAddress:  net.ParseIP("192.0.2.10"),
+		UserAgent:  "test-agent",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != "cookie-1" {
+		t.Errorf("Create returned %q, want the cookie hash", hash)
+	}
+
+	got, err := s.Sessions.GetByCookieHash(t.Context(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uuid.Parse(got.ID); err != nil {
+		t.Errorf("ID = %q, want a UUID", got.ID)
+	}
+	if got.UserID != u.ID || got.CookieHash != hash || got.UserAgent != "test-agent" {
+		t.Errorf("session = %+v", got)
+	}
+	if !got.IPAddress.Equal(net.ParseIP("192.0.2.10")) {
+		t.Errorf("IPAddress = %v, want 192.0.2.10", got.IPAddress)
+	}
+	if got.ExpiresAt == nil || !got.ExpiresAt.Equal(expires.Truncate(time.Microsecond)) {
+		t.Errorf("ExpiresAt = %v, want %v", got.ExpiresAt, expires)
+	}
+	if got.CreatedAt.Before(before) {
+		t.Errorf("CreatedAt = %v, want now", got.CreatedAt)
+	}
+
+	list, err := s.Sessions.ListByUserID(t.Context(), u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != got.ID || !list[0].IPAddress.Equal(got.IPAddress) {
+		t.Errorf("ListByUserID = %+v, want [%+v]", list, got)
+	}
+}
+
+func testSessionReplaced(t *testing.T, s Stores) {
+	ctx := t.Context()
+	u := mustCreate(t, s, &repository.User{Email: "ada@example.com"})
+	first := mustSession(t, s, u.ID, "cookie-1", nil)
+	second := mustSession(t, s, u.ID, "cookie-2", nil)
+
+	if _, err := s.Sessions.GetByCookieHash(ctx, first); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("first session after a second login: error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := s.Sessions.GetByCookieHash(ctx, second); err != nil {
+		t.Errorf("second session: %v", err)
+	}
+}
+
+func testSessionUnknownUser(t *testing.T, s Stores) {
+	_, err := s.Sessions.Create(t.Context(), repository.Session{UserID: uuid.NewString(), CookieHash: "cookie-1"})
+	if !errors.Is(err, repository.ErrForeignKeyViolation) {
+		t.Fatalf("Create for a missing user: err = %v, want ErrForeignKeyViolation", err)
+	}
+}
+
+func testUpdateExpiry(t *testing.T, s Stores) {
+	u := mustCreate(t, s, &repository.User{Email: "ada@example.com"})
+	hash := mustSession(t, s, u.ID, "cookie-1", nil)
+
+	expires := time.Now().Add(48 * time.Hour)
+	if err := s.Sessions.UpdateExpiry(t.Context(), hash, expires); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Sessions.GetByCookieHash(t.Context(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ExpiresAt == nil || !got.ExpiresAt.Equal(expires.Truncate(time.Microsecond)) {
+		t.Errorf("ExpiresAt = %v, want %v", got.ExpiresAt, expires)
+	}
+}
+
+func testDeleteSessions(t *testing.T, s Stores) {
+	ctx := t.Context()
+	ada := mustCreate(t, s, &repository.User{Email: "ada@example.com"})
+	grace := mustCreate(t, s, &repository.User{Email: "grace@example.com"})
+	adaHash := mustSession(t, s, ada.ID, "cookie-ada", nil)
+	graceHash := mustSession(t, s, grace.ID, "cookie-grace", nil)
+
+	if err := s.Sessions.DeleteByCookieHash(ctx, adaHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Sessions.GetByCookieHash(ctx, adaHash); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted session: error = %v, want sql.ErrNoRows", err)
+	}
+
+	if err := s.Sessions.DeleteByUserID(ctx, grace.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Sessions.GetByCookieHash(ctx, graceHash); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("revoked session: error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testDeleteExpired(t *testing.T, s Stores) {
+	ctx := t.Context()
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	var hashes []string
+	for i, exp := range []*time.Time{&past, &future, nil} {
+		u := mustCreate(t, s, &repository.User{Email: string(rune('a'+i)) + "@example.com"})
+		hashes = append(hashes, mustSession(t, s, u.ID, "cookie-"+u.Email, exp))
+	}
+
+	n, err := s.Sessions.DeleteExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteExpired removed %d sessions, want 1", n)
+	}
+	if _, err := s.Sessions.GetByCookieHash(ctx, hashes[0]); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expired session survived: %v", err)
+	}
+	for _, hash := range hashes[1:] {
+		if _, err := s.Sessions.GetByCookieHash(ctx, hash); err != nil {
+			t.Errorf("live session %s removed: %v", hash, err)
+		}
+	}
+}
+
+func testTxCommit(t *testing.T, s Stores) {
+	var id string
+	err := s.Tx.WithTx(t.Context(), func(ctx context.Context, tx services.Stores) error {
+		u, err := tx.Users.CreateUser(ctx, &repository.User{Email: "ada@example.com"})
+		if err != nil {
+			return err
+		}
+		id = u.ID
+		_, err = tx.Sessions.Create(ctx, repository.Session{UserID: u.ID, CookieHash: "cookie-1"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u, err := s.Users.GetUserByID(t.Context(), id); err != nil || u == nil {
+		t.Errorf("committed user: %v, %v", u, err)
+	}
+	if _, err := s.Sessions.GetByCookieHash(t.Context(), "cookie-1"); err != nil {
+		t.Errorf("committed session: %v", err)
+	}
+}
+
+func testTxRollback(t *testing.T, s Stores) {
+	kept := mustCreate(t, s, &repository.User{Email: "grace@example.com"})
+	boom := errors.New("boom")
+
+	err := s.Tx.WithTx(t.Context(), func(ctx context.Context, tx services.Stores) error {
+		if _, err := tx.Users.CreateUser(ctx, &repository.User{Email: "ada@example.com"}); err != nil {
+			return err
+		}
+		if err := tx.Users.DeleteUser(ctx, kept.ID); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("WithTx error = %v, want %v", err, boom)
+	}
+
+	if u, err := s.Users.GetUserByEmail(t.Context(), "ada@example.com"); u != nil || err != nil {
+		t.Errorf("rolled back insert is visible: %v, %v", u, err)
+	}
+	if u, err := s.Users.GetUserByID(t.Context(), kept.ID); u == nil || err != nil {
+		t.Errorf("rolled back delete took effect: %v, %v", u, err)
+	}
+}
+
+func testImpersonation(t *testing.T, s Stores) {
+	ctx := t.Context()
+	admin := mustCreate(t, s, &repository.User{Email: "admin@example.com"})
+	ada := mustCreate(t, s, &repository.User{Email: "ada@example.com"})
+	grace := mustCreate(t, s, &repository.User{Email: "grace@example.com"})
+
+	imp := &repository.Impersonation{
+		AdminID:      admin.ID,
+		TargetUserID: ada.ID,
+		CookieHash:   "imp-1",
+		ExpiresAt:    time.Now().Add(time.Hour),
+		IPAddress:    net.ParseIP("192.0.2.10"),
+		UserAgent:    "test-agent",
+	}
+	if err := s.Impersonations.CreateImpersonation(ctx, imp); err != nil {
+		t.Fatal(err)
+	}
+	if imp.ID == "" || imp.CreatedAt.IsZero() {
+		t.Errorf("created impersonation has ID %q and CreatedAt %v", imp.ID, imp.CreatedAt)
+	}
+	got, err := s.Impersonations.GetImpersonation(ctx, "imp-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ID != imp.ID || got.AdminID != admin.ID || got.TargetUserID != ada.ID ||
+		!got.ExpiresAt.Equal(imp.ExpiresAt.Truncate(time.Microsecond)) || !got.IPAddress.Equal(imp.IPAddress) ||
+		got.UserAgent != imp.UserAgent {
+		t.Errorf("GetImpersonation:\n got %+v\nwant %+v", got, imp)
+	}
+	if got, err := s.Impersonations.GetImpersonation(ctx, "unknown"); got != nil || err != nil {
+		t.Errorf("unknown cookie: got %+v, %v; want nil, nil", got, err)
+	}
+
+	unknown := &repository.Impersonation{AdminID: admin.ID, TargetUserID: uuid.NewString(), CookieHash: "imp-2", ExpiresAt: time.Now()}
+	if err := s.Impersonations.CreateImpersonation(ctx, unknown); !errors.Is(err, repository.ErrForeignKeyViolation) {
+		t.Errorf("unknown target: err = %v, want ErrForeignKeyViolation", err)
+	}
+
+	expired := &repository.Impersonation{AdminID: admin.ID, TargetUserID: grace.ID, CookieHash: "imp-3", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := s.Impersonations.CreateImpersonation(ctx, expired); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Impersonations.DeleteExpiredImpersonations(ctx); err != nil || n != 1 {
+		t.Errorf("DeleteExpiredImpersonations = %d, %v; want 1", n, err)
+	}
+
+	// Impersonations go with the target, as with the admin.
+	if err := s.Users.DeleteUser(ctx, ada.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Impersonations.GetImpersonation(ctx, "imp-1"); got != nil || err != nil {
+		t.Errorf("after deleting the target: got %+v, %v; want nil, nil", got, err)
+	}
+	if err := s.Impersonations.DeleteImpersonation(ctx, "imp-1"); err != nil {
+		t.Errorf("DeleteImpersonation of a gone impersonation: %v", err)
+	}
+}
+
+func testSeededRoles(t *testing.T, s Stores) {
+	roles, err := s.Roles.ListRoles(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := slices.IndexFunc(roles, func(r repository.Role) bool { return r.Name == repository.RoleAdmin })
+	if i < 0 {
+		t.Fatalf("ListRoles = %+v, want the admin role", roles)
+	}
+	for _, p := range []string{repository.PermAuditRead, repository.PermUsersImpersonate, repository.PermUsersRead, repository.PermUsersWrite} {
+		if !slices.Contains(roles[i].Permissions, p) {
+			t.Errorf("admin permissions = %v, want %s", roles[i].Permissions, p)
+		}
+	}
+}
+
+func testAssignRole(t *testing.T, s Stores) {
+	ctx := t.Context()
+	u := mustCreate(t, s, &repository.User{Email: "ada@example.com"})
+
+	assertNames := func(name string, get func(context.Context, string) ([]string, error), want []string) {
+		t.Helper()
+		got, err := get(ctx, u.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	assertNames("UserPermissions", s.Roles.UserPermissions, nil)
+
+	// Assigning twice is harmless.
+	for range 2 {
+		if err := s.Roles.AssignRole(ctx, u.ID, repository.RoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertNames("UserRoles", s.Roles.UserRoles, []string{repository.RoleAdmin})
+	assertNames("UserPermissions", s.Roles.UserPermissions, []string{
+		repository.PermAuditRead, repository.PermUsersImpersonate, repository.PermUsersRead, repository.PermUsersWrite,
+	})
+
+	if err := s.Roles.UnassignRole(ctx, u.ID, repository.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	assertNames("UserRoles", s.Roles.UserRoles, nil)
+
+	// The roles of a deleted user go with it.
+	if err := s.Roles.AssignRole(ctx, u.ID, repository.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Users.DeleteUser(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	assertNames("UserRoles", s.Roles.UserRoles, nil)
+}
+
+func testAssignRoleUnknown(t *testing.T, s Stores) {
+	u := mustCreate(t, s, &repository.User{Email: "ada@example.com"})
+	if err := s.Roles.AssignRole(t.Context(), u.ID, "no-such-role"); !errors.Is(err, repository.ErrForeignKeyViolation) {
+		t.Errorf("unknown role: err = %v, want ErrForeignKeyViolation", err)
+	}
+	if err := s.Roles.AssignRole(t.Context(), uuid.NewString(), repository.RoleAdmin); !errors.Is(err, repository.ErrForeignKeyViolation) {
+		t.Errorf("unknown user: err = %v, want ErrForeignKeyViolation", err)
+	}
+}
+
+func testAuditEvents(t *testing.T, s Stores) {
+	ctx := t.Context()
+	admin, target := uuid.NewString(), uuid.NewString()
+	id := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+
+	before := time.Now().Add(-time.Second)
+	first := &repository.AuditEvent{
+		Type:         "admin.user_suspended",
+		ActorID:      id(admin),
+		TargetUserID: id(target),
+		IPAddress:    net.ParseIP("192.0.2.10"),
+		UserAgent:    "test-agent",
+		RequestID:    "req-1",
+		Metadata:     map[string]string{"email": "ada@example.com"},
+	}
+	for _, e := range []*repository.AuditEvent{
+		first,
+		{Type: "admin.email_verified", ActorID: id(admin), TargetUserID: id(uuid.NewString())},
+		{Type: "login", ActorID: id(target)},
+	} {
+		if err := s.Audit.RecordAuditEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.ID == 0 || first.CreatedAt.Before(before) {
+		t.Errorf("recorded event has ID %d and CreatedAt %v", first.ID, first.CreatedAt)
+	}
+
+	types := func(f repository.AuditFilter) []string {
+		t.Helper()
+		events, err := s.Audit.ListAuditEvents(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []string
+		for _, e := range events {
+			types = append(types, e.Type)
+		}
+		return types
+	}
+	for _, tc := range []struct {
+		name   string
+		filter repository.AuditFilter
+		want   []string
+	}{
+		{"all", repository.AuditFilter{Limit: 10}, []string{"login", "admin.email_verified", "admin.user_suspended"}},
+		{"limit", repository.AuditFilter{Limit: 1}, []string{"login"}},
+		{"actor", repository.AuditFilter{ActorID: admin, Limit: 10}, []string{"admin.email_verified", "admin.user_suspended"}},
+		{"target", repository.AuditFilter{TargetUserID: target, Limit: 10}, []string{"admin.user_suspended"}},
+		{"type", repository.AuditFilter{Type: "login", Limit: 10}, []string{"login"}},
+		{"before", repository.AuditFilter{Before: first.ID + 1, Limit: 10}, []string{"admin.user_suspended"}},
+	} {
+		if got := types(tc.filter); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	events, err := s.Audit.ListAuditEvents(ctx, repository.AuditFilter{TargetUserID: target, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := events[0]
+	if got.ID != first.ID || got.ActorID != first.ActorID || !got.IPAddress.Equal(first.IPAddress) ||
+		got.UserAgent != first.UserAgent || got.RequestID != first.RequestID ||
+		got.Metadata["email"] != "ada@example.com" || !got.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("listed event:\n got %+v\nwant %+v", got, first)
+	}
+}
+
+func testDeleteAuditEvents(t *testing.T, s Stores) {
+	ctx := t.Context()
+	for _, typ := range []string{"login", "logout"} {
+		if err := s.Audit.RecordAuditEvent(ctx, &repository.AuditEvent{Type: typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := s.Audit.DeleteAuditEventsBefore(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("DeleteAuditEventsBefore(an hour ago) = %d, %v; want 0", n, err)
+	}
+	if n, err := s.Audit.DeleteAuditEventsBefore(ctx, time.Now().Add(time.Hour)); err != nil || n != 2 {
+		t.Errorf("DeleteAuditEventsBefore(in an hour) = %d, %v; want 2", n, err)
+	}
+	events, err := s.Audit.ListAuditEvents(ctx, repository.AuditFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("%d events left, want none", len(events))
+	}
+
+	// IDs are not reused after a purge.
+	e := &repository.AuditEvent{Type: "login"}
+	if err := s.Audit.RecordAuditEvent(ctx, e); err != nil {
+		t.Fatal(err)
+	}
+	if e.ID <= 2 {
+		t.Errorf("ID after purge = %d, want > 2", e.ID)
+	}
+}
+
+func mustCreate(t *testing.T, s Stores, u *repository.User) *repository.User {
+	t.Helper()
+	created, err := s.Users.CreateUser(t.Context(), u)
+	if err != nil {
+		t.Fatalf("CreateUser(%s): %v", u.Email, err)
+	}
+	return created
+}
+
+func mustSession(t *testing.T, s Stores, userID, hash string, expires *time.Time) string {
+	t.Helper()
+	got, err := s.Sessions.Create(t.Context(), repository.Session{UserID: userID, CookieHash: hash, ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("Create session: %v", err)
+	}
+	return got
+}
+
+func assertUser(t *testing.T, label string, got, want *repository.User) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("%s: user not found", label)
+	}
+	if got.ID != want.ID || got.Email != want.Email || got.PasswordHash != want.PasswordHash ||
+		got.GoogleID != want.GoogleID || got.Status != want.Status || got.StatusReason != want.StatusReason ||
+		got.DisplayName != want.DisplayName || got.AvatarURL != want.AvatarURL || got.AvatarKey != want.AvatarKey ||
+		got.Locale != want.Locale || got.Timezone != want.Timezone ||
+		got.StatusExpiresAt.Valid != want.StatusExpiresAt.Valid ||
+		!got.StatusExpiresAt.Time.Equal(want.StatusExpiresAt.Time.Truncate(time.Microsecond)) ||
+		got.EmailVerifiedAt.Valid != want.EmailVerifiedAt.Valid ||
+		!got.EmailVerifiedAt.Time.Equal(want.EmailVerifiedAt.Time.Truncate(time.Microsecond)) ||
+		!got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Errorf("%s:\n got %+v\nwant %+v", label, got, want)
+	}
+}
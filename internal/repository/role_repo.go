@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+// The role and permissions seeded by the migrations.
+const (
+	RoleAdmin = "admin"
+
+	PermAuditRead = "audit:read"
+
+	PermUsersRead        = "users:read"
+	PermUsersWrite       = "users:write"
+	PermUsersImpersonate = "users:impersonate"
+)
+
+// Role is a named set of permissions.
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+}
+
+type RoleRepository struct {
+	DB Querier
+}
+
+// ListRoles returns every role with its permissions, ordered by name.
+func (rr *RoleRepository) ListRoles(ctx context.Context) (_ []Role, err error) {
+	ctx, span := startSpan(ctx, "RoleRepository.ListRoles", "roles")
+	defer func() { endSpan(span, err) }()
+
+	rows, err := rr.DB.QueryContext(ctx, `
+        SELECT r.name, r.description, rp.permission
+        FROM roles r
+        LEFT JOIN role_permissions rp ON rp.role = r.name
+        ORDER BY r.name, rp.permission
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []Role
+	for rows.Next() {
+		var name, description string
+		var perm sql.NullString
+		if err := rows.Scan(&name, &description, &perm); err != nil {
+			return nil, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != name {
+			roles = append(roles, Role{Name: name, Description: description})
+		}
+		if perm.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, perm.String)
+		}
+	}
+	return roles, rows.Err()
+}
+
+// UserRoles returns the names of the roles assigned to a user, in order.
+func (rr *RoleRepository) UserRoles(ctx context.Context, userID string) (_ []string, err error) {
+	ctx, span := startSpan(ctx, "RoleRepository.UserRoles", "user_roles")
+	defer func() { endSpan(span, err) }()
+
+	return rr.names(ctx, `
+        SELECT role FROM user_roles WHERE user_id = $1 ORDER BY role
+    `, userID)
+}
+
+// UserPermissions returns the permissions a user holds through any of
+// their roles, in order and without duplicates.
+func (rr *RoleRepository) UserPermissions(ctx context.Context, userID string) (_ []string, err error) {
+	ctx, span := startSpan(ctx, "RoleRepository.UserPermissions", "role_permissions")
+	defer func() { endSpan(span, err) }()
+
+	return rr.names(ctx, `
+        SELECT DISTINCT rp.permission
+        FROM user_roles ur
+        JOIN role_permissions rp ON rp.role = ur.role
+        WHERE ur.user_id = $1
+        ORDER BY rp.permission
+    `, userID)
+}
+
+// AssignRole gives a user a role. Assigning a role twice is not an error;
+// an unknown user or role fails with ErrForeignKeyViolation.
+func (rr *RoleRepository) AssignRole(ctx context.Context, userID, role string) (err error) {
+	ctx, span := startSpan(ctx, "RoleRepository.AssignRole", "user_roles")
+	defer func() { endSpan(span, err) }()
+
+	_, err = rr.DB.ExecContext(ctx, `
+        INSERT INTO user_roles (user_id, role) VALUES ($1, $2)
+        ON CONFLICT DO NOTHING
+    `, userID, role)
+	return mapError(err)
+}
+
+// UnassignRole takes a role away from a user, if they have it.
+func (rr *RoleRepository) UnassignRole(ctx context.Context, userID, role string) (err error) {
+	ctx, span := startSpan(ctx, "RoleRepository.UnassignRole", "user_roles")
+	defer func() { endSpan(span, err) }()
+
+	_, err = rr.DB.ExecContext(ctx, `
+        DELETE FROM user_roles WHERE user_id = $1 AND role = $2
+    `, userID, role)
+	return err
+}
+
+func (rr *RoleRepository) names(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := rr.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
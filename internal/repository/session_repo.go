@@ -18,61 +18,139 @@ type Session struct {
 }
 
 type SessionRepository struct {
-	DB *sql.DB
+	DB Querier
 }
 
-func (ss *SessionRepository) Create(ctx context.Context, s Session) (string, error) {
+func (ss *SessionRepository) Create(ctx context.Context, s Session) (_ string, err error) {
+	ctx, span := startSpan(ctx, "SessionRepository.Create", "sessions")
+	defer func() { endSpan(span, err) }()
+
 	var cookieHash string
-	err := ss.DB.QueryRowContext(ctx, `
+	err = ss.DB.QueryRowContext(ctx, `
         INSERT INTO sessions (user_id, cookie_hash, created_at, expires_at, ip_address, user_agent)
-        VALUES ($1, $2, NOW(), NULL, $3, $4)
+        VALUES ($1, $2, NOW(), $3, $4, $5)
         ON CONFLICT (user_id) DO UPDATE SET
             cookie_hash = EXCLUDED.cookie_hash,
             created_at = NOW(),
-            expires_at = NULL,
+            expires_at = EXCLUDED.expires_at,
             ip_address = EXCLUDED.ip_address,
             user_agent = EXCLUDED.user_agent
         RETURNING cookie_hash
-    `, s.UserID, s.CookieHash, s.IPAddress, s.UserAgent).Scan(&cookieHash)
+    `, s.UserID, s.CookieHash, s.ExpiresAt, ipAddress(s.IPAddress), s.UserAgent).Scan(&cookieHash)
 	if err != nil {
-		return "", err
+		return "", mapError(err)
 	}
 	return cookieHash, nil
 }
 
-func (ss *SessionRepository) GetByCookieHash(ctx context.Context, cookieHash string) (Session, error) {
-	var s Session
-	err := ss.DB.QueryRowContext(ctx, `
-        SELECT id, user_id, cookie_hash, created_at, expires_at, ip_address, user_agent
+func (ss *SessionRepository) GetByCookieHash(ctx context.Context, cookieHash string) (_ Session, err error) {
+	ctx, span := startSpan(ctx, "SessionRepository.GetByCookieHash", "sessions")
+	defer func() { endSpan(span, err) }()
+
+	s, err := scanSession(ss.DB.QueryRowContext(ctx, `
+        SELECT `+sessionColumns+`
         FROM sessions
         WHERE cookie_hash = $1
-    `, cookieHash).Scan(&s.ID, &s.UserID, &s.CookieHash, &s.CreatedAt, &s.ExpiresAt, &s.IPAddress, &s.UserAgent)
+    `, cookieHash))
 	if err != nil {
 		return Session{}, err
 	}
 	return s, nil
 }
 
-func (ss *SessionRepository) DeleteByCookieHash(ctx context.Context, cookieHash string) error {
-	_, err := ss.DB.ExecContext(ctx, `
+func (ss *SessionRepository) DeleteByCookieHash(ctx context.Context, cookieHash string) (err error) {
+	ctx, span := startSpan(ctx, "SessionRepository.DeleteByCookieHash", "sessions")
+	defer func() { endSpan(span, err) }()
+
+	_, err = ss.DB.ExecContext(ctx, `
         DELETE FROM sessions
         WHERE cookie_hash = $1
     `, cookieHash)
 	return err
 }
 
-func (ss *SessionRepository) UpdateExpiry(ctx context.Context, cookieHash string, expiresAt time.Time) error {
-	_, err := ss.DB.ExecContext(ctx, `
+func (ss *SessionRepository) UpdateExpiry(ctx context.Context, cookieHash string, expiresAt time.Time) (err error) {
+	ctx, span := startSpan(ctx, "SessionRepository.UpdateExpiry", "sessions")
+	defer func() { endSpan(span, err) }()
+
+	_, err = ss.DB.ExecContext(ctx, `
         UPDATE sessions
         SET expires_at = $1
         WHERE cookie_hash = $2
     `, expiresAt, cookieHash)
 	return err
 }
-func (ss *SessionRepository) DeleteByUserID(ctx context.Context, userID string) error {
-	_, err := ss.DB.ExecContext(ctx, `
+
+// ListByUserID returns the user's sessions, newest first.
+func (ss *SessionRepository) ListByUserID(ctx context.Context, userID string) (_ []Session, err error) {
+	ctx, span := startSpan(ctx, "SessionRepository.ListByUserID", "sessions")
+	defer func() { endSpan(span, err) }()
+
+	rows, err := ss.DB.QueryContext(ctx, `
+        SELECT `+sessionColumns+`
+        FROM sessions
+        WHERE user_id = $1
+        ORDER BY created_at DESC, id
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+func (ss *SessionRepository) DeleteByUserID(ctx context.Context, userID string) (err error) {
+	ctx, span := startSpan(ctx, "SessionRepository.DeleteByUserID", "sessions")
+	defer func() { endSpan(span, err) }()
+
+	_, err = ss.DB.ExecContext(ctx, `
         DELETE FROM sessions
         WHERE user_id = $1
     `, userID)
 	return err
 }
+
+func (ss *SessionRepository) DeleteExpired(ctx context.Context) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "SessionRepository.DeleteExpired", "sessions")
+	defer func() { endSpan(span, err) }()
+
+	res, err := ss.DB.ExecContext(ctx, `
+        DELETE FROM sessions
+        WHERE expires_at < NOW()
+    `)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// sessionColumns is the column list scanned by scanSession.
+const sessionColumns = "id, user_id, cookie_hash, created_at, expires_at, host(ip_address), user_agent"
+
+func scanSession(row scanner) (Session, error) {
+	var s Session
+	var ip sql.NullString
+	if err := row.Scan(&s.ID, &s.UserID, &s.CookieHash, &s.CreatedAt, &s.ExpiresAt, &ip, &s.UserAgent); err != nil {
+		return Session{}, err
+	}
+	s.IPAddress = net.ParseIP(ip.String)
+	return s, nil
+}
+
+// ipAddress converts ip for the INET column; the driver would otherwise
+// send a net.IP as bytea.
+func ipAddress(ip net.IP) sql.NullString {
+	if ip == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: ip.String(), Valid: true}
+}
@@ -0,0 +1,241 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sort orders for ListUsers. Both break ties on the user ID, so the order
+// is total and pages never overlap.
+const (
+	SortCreatedAt = "created_at"
+	SortEmail     = "email"
+)
+
+// Providers a user can sign in with, for UserFilter.Provider.
+const (
+	ProviderPassword = "password"
+	ProviderGoogle   = "google"
+)
+
+// ErrInvalidCursor is returned for a cursor that was not produced by a
+// listing with the same sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// UserFilter narrows ListUsers. Zero fields do not filter.
+type UserFilter struct {
+	// Email matches a case-insensitive substring of the address.
+	Email string
+	// Provider is ProviderPassword or ProviderGoogle.
+	Provider string
+	// Verified selects users whose email is, or is not, verified.
+	Verified *bool
+	// Status is one of the account statuses.
+	Status string
+	// CreatedFrom is inclusive and CreatedTo exclusive.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+}
+
+type ListUsersOptions struct {
+	Filter UserFilter
+	// Sort is SortCreatedAt (the default) or SortEmail.
+	Sort string
+	Desc bool
+	// After is the Next cursor of the previous page; empty for the first.
+	After string
+	// Limit is the page size; it must be positive.
+	Limit int
+}
+
+// UserPage is one page of a listing. Total counts every user matching the
+// filter, on all pages; Next is empty on the last page.
+type UserPage struct {
+	Users []*User
+	Total int
+	Next  string
+}
+
+// UserCursor is the position after the last user of a page: its sort key
+// and ID.
+type UserCursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   string `json:"id"`
+}
+
+// CursorAfter returns the cursor following u in a listing sorted by sort.
+func CursorAfter(u *User, sort string) UserCursor {
+	key := u.Email
+	if sort != SortEmail {
+		key = u.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return UserCursor{Sort: sort, Key: key, ID: u.ID}
+}
+
+// Encode returns the opaque form of c handed to clients.
+func (c UserCursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeUserCursor parses s, which must come from a listing sorted by sort.
+func DecodeUserCursor(s, sort string) (UserCursor, error) {
+	var c UserCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.Sort != sort || c.ID == "" {
+		return UserCursor{}, ErrInvalidCursor
+	}
+	if sort == SortCreatedAt {
+		if _, err := time.Parse(time.RFC3339Nano, c.Key); err != nil {
+			return UserCursor{}, ErrInvalidCursor
+		}
+	}
+	return c, nil
+}
+
+// Validate checks the options and fills in the default sort.
+func (o *ListUsersOptions) Validate() error {
+	if o.Sort == "" {
+		o.Sort = SortCreatedAt
+	}
+	if o.Sort != SortCreatedAt && o.Sort != SortEmail {
+		return fmt.Errorf("unknown sort %q", o.Sort)
+	}
+	switch o.Filter.Provider {
+	case "", ProviderPassword, ProviderGoogle:
+	default:
+		return fmt.Errorf("unknown provider %q", o.Filter.Provider)
+	}
+	if o.Filter.Status != "" && !ValidStatus(o.Filter.Status) {
+		return fmt.Errorf("unknown status %q", o.Filter.Status)
+	}
+	if o.Limit <= 0 {
+		return fmt.Errorf("invalid limit %d", o.Limit)
+	}
+	if o.After != "" {
+		if _, err := DecodeUserCursor(o.After, o.Sort); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListUsers returns one page of the users matching opts.Filter, using
+// keyset pagination so that deep pages cost the same as the first.
+func (r *UserRepo) ListUsers(ctx context.Context, opts ListUsersOptions) (_ *UserPage, err error) {
+	ctx, span := startSpan(ctx, "UserRepo.ListUsers", "users")
+	defer func() { endSpan(span, err) }()
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	var q query
+	q.filter(opts.Filter)
+
+	page := &UserPage{}
+	err = r.db.QueryRowContext(ctx, `SELECT count(*) FROM users`+q.where(), q.args...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	// Emails are compared bytewise so the order does not depend on the
+	// database collation.
+	key := "created_at"
+	if opts.Sort == SortEmail {
+		key = `email COLLATE "C"`
+	}
+	dir, cmp := "ASC", ">"
+	if opts.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if opts.After != "" {
+		c, _ := DecodeUserCursor(opts.After, opts.Sort)
+		var after any = c.Key
+		if opts.Sort == SortCreatedAt {
+			after, _ = time.Parse(time.RFC3339Nano, c.Key)
+		}
+		q.add(fmt.Sprintf("(%s, id) %s (%s, %s)", key, cmp, q.arg(after), q.arg(c.ID)))
+	}
+
+	// One extra row tells whether there is a next page.
+	rows, err := r.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users`+q.where()+
+		fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %s", key, dir, dir, q.arg(opts.Limit+1)), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Users = append(page.Users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Users) > opts.Limit {
+		page.Users = page.Users[:opts.Limit]
+		page.Next = CursorAfter(page.Users[opts.Limit-1], opts.Sort).Encode()
+	}
+	return page, nil
+}
+
+// query accumulates a WHERE clause and its positional arguments.
+type query struct {
+	conds []string
+	args  []any
+}
+
+func (q *query) arg(v any) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func (q *query) add(cond string) {
+	q.conds = append(q.conds, cond)
+}
+
+func (q *query) where() string {
+	if len(q.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conds, " AND ")
+}
+
+func (q *query) filter(f UserFilter) {
+	if f.Email != "" {
+		q.add(fmt.Sprintf("strpos(lower(email), lower(%s)) > 0", q.arg(f.Email)))
+	}
+	switch f.Provider {
+	case ProviderPassword:
+		q.add("password_hash IS NOT NULL")
+	case ProviderGoogle:
+		q.add("google_id IS NOT NULL")
+	}
+	if f.Verified != nil {
+		if *f.Verified {
+			q.add("email_verified_at IS NOT NULL")
+		} else {
+			q.add("email_verified_at IS NULL")
+		}
+	}
+	if f.Status != "" {
+		q.add("status = " + q.arg(f.Status))
+	}
+	if !f.CreatedFrom.IsZero() {
+		q.add("created_at >= " + q.arg(f.CreatedFrom))
+	}
+	if !f.CreatedTo.IsZero() {
+		q.add("created_at < " + q.arg(f.CreatedTo))
+	}
+}
@@ -8,13 +8,82 @@ import (
 	"time"
 )
 
+// Account statuses. Only active users, and suspended users whose
+// suspension has lapsed, may sign in.
+const (
+	StatusActive          = "active"
+	StatusSuspended       = "suspended"
+	StatusBanned          = "banned"
+	StatusPendingDeletion = "pending_deletion"
+)
+
+// ValidStatus reports whether s is one of the account statuses.
+func ValidStatus(s string) bool {
+	switch s {
+	case StatusActive, StatusSuspended, StatusBanned, StatusPendingDeletion:
+		return true
+	}
+	return false
+}
+
 type User struct {
-	ID           string
-	Email        string
-	PasswordHash sql.NullString
-	GoogleID     sql.NullString
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID              string
+	Email           string
+	PasswordHash    sql.NullString
+	GoogleID        sql.NullString
+	EmailVerifiedAt sql.NullTime
+	// Status is one of the account statuses; empty means StatusActive on
+	// create. StatusReason is shown to the user, and StatusExpiresAt is
+	// when a suspension lapses, if it does.
+	Status          string
+	StatusReason    string
+	StatusExpiresAt sql.NullTime
+	// DisplayName, Locale (a BCP 47 tag) and Timezone (an IANA name) are
+	// empty until the user or their identity provider sets them. The
+	// avatar is the uploaded image stored under AvatarKey, if any, else
+	// the picture at AvatarURL.
+	DisplayName string
+	AvatarURL   string
+	AvatarKey   string
+	Locale      string
+	Timezone    string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Active reports whether the user may sign in.
+func (u *User) Active() bool {
+	switch u.Status {
+	case StatusActive:
+		return true
+	case StatusSuspended:
+		return u.SuspensionLapsed()
+	}
+	return false
+}
+
+// SuspensionLapsed reports whether the user is suspended until a time
+// that has passed. The status stays suspended until changed, but the user
+// may sign in again.
+func (u *User) SuspensionLapsed() bool {
+	return u.Status == StatusSuspended && u.StatusExpiresAt.Valid && !u.StatusExpiresAt.Time.After(time.Now())
+}
+
+// userColumns is the column list scanned by scanUser.
+const userColumns = "id, email, password_hash, google_id, email_verified_at, status, status_reason, status_expires_at, " +
+	"display_name, avatar_url, avatar_key, locale, timezone, created_at, updated_at"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row scanner) (*User, error) {
+	u := &User{}
+	err := row.Scan(
+		&u.ID, &u.Email, &u.PasswordHash, &u.GoogleID, &u.EmailVerifiedAt, &u.Status, &u.StatusReason, &u.StatusExpiresAt,
+		&u.DisplayName, &u.AvatarURL, &u.AvatarKey, &u.Locale, &u.Timezone, &u.CreatedAt, &u.UpdatedAt,
+	)
+	return u, err
 }
 
 type GoogleUser struct {
@@ -29,38 +98,48 @@ type GoogleUser struct {
 }
 
 type UserRepo struct {
-	db     *sql.DB
+	db     Querier
 	logger *slog.Logger
 }
 
-func NewUserRepo(db *sql.DB, log *slog.Logger) *UserRepo {
+func NewUserRepo(db Querier, log *slog.Logger) *UserRepo {
 	return &UserRepo{
 		db:     db,
 		logger: log,
 	}
 }
 
-func (r *UserRepo) CreateUser(ctx context.Context, u *User) (*User, error) {
+func (r *UserRepo) CreateUser(ctx context.Context, u *User) (_ *User, err error) {
+	ctx, span := startSpan(ctx, "UserRepo.CreateUser", "users")
+	defer func() { endSpan(span, err) }()
+
+	status := u.Status
+	if status == "" {
+		status = StatusActive
+	}
 	row := r.db.QueryRowContext(ctx, `
-        INSERT INTO users (email, password_hash, google_id, created_at, updated_at)
-        VALUES ($1, $2, $3, NOW(), NOW())
-        RETURNING id, email, password_hash, google_id, created_at, updated_at`,
-		u.Email, u.PasswordHash, u.GoogleID)
-	user := &User{}
-	err := row.Scan(
-		&user.ID, &user.Email, &user.PasswordHash, &user.GoogleID, &user.CreatedAt, &user.UpdatedAt,
-	)
-	return user, err
+        INSERT INTO users (email, password_hash, google_id, email_verified_at, status, status_reason, status_expires_at,
+            display_name, avatar_url, avatar_key, locale, timezone, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW(), NOW())
+        RETURNING `+userColumns,
+		u.Email, u.PasswordHash, u.GoogleID, u.EmailVerifiedAt, status, u.StatusReason, u.StatusExpiresAt,
+		u.DisplayName, u.AvatarURL, u.AvatarKey, u.Locale, u.Timezone)
+	user, err := scanUser(row)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return user, nil
 }
 
-func (r *UserRepo) GetUserByID(ctx context.Context, id string) (*User, error) {
+func (r *UserRepo) GetUserByID(ctx context.Context, id string) (_ *User, err error) {
+	ctx, span := startSpan(ctx, "UserRepo.GetUserByID", "users")
+	defer func() { endSpan(span, err) }()
+
 	row := r.db.QueryRowContext(ctx, `
-        SELECT id, email, password_hash, google_id, created_at, updated_at
+        SELECT `+userColumns+`
         FROM users WHERE id = $1`, id)
-	u := &User{}
-	if err := row.Scan(
-		&u.ID, &u.Email, &u.PasswordHash, &u.GoogleID, &u.CreatedAt, &u.UpdatedAt,
-	); err != nil {
+	u, err := scanUser(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -69,14 +148,15 @@ func (r *UserRepo) GetUserByID(ctx context.Context, id string) (*User, error) {
 	return u, nil
 }
 
-func (r *UserRepo) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+func (r *UserRepo) GetUserByEmail(ctx context.Context, email string) (_ *User, err error) {
+	ctx, span := startSpan(ctx, "UserRepo.GetUserByEmail", "users")
+	defer func() { endSpan(span, err) }()
+
 	row := r.db.QueryRowContext(ctx, `
-        SELECT id, email, password_hash, google_id, created_at, updated_at
+        SELECT `+userColumns+`
         FROM users WHERE email = $1`, email)
-	u := &User{}
-	if err := row.Scan(
-		&u.ID, &u.Email, &u.PasswordHash, &u.GoogleID, &u.CreatedAt, &u.UpdatedAt,
-	); err != nil {
+	u, err := scanUser(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -85,14 +165,15 @@ func (r *UserRepo) GetUserByEmail(ctx context.Context, email string) (*User, err
 	return u, nil
 }
 
-func (r *UserRepo) GetUserByGoogleID(ctx context.Context, gid string) (*User, error) {
+func (r *UserRepo) GetUserByGoogleID(ctx context.Context, gid string) (_ *User, err error) {
+	ctx, span := startSpan(ctx, "UserRepo.GetUserByGoogleID", "users")
+	defer func() { endSpan(span, err) }()
+
 	row := r.db.QueryRowContext(ctx, `
-        SELECT id, email, password_hash, google_id, created_at, updated_at
+        SELECT `+userColumns+`
         FROM users WHERE google_id = $1`, sql.NullString{String: gid, Valid: true})
-	u := &User{}
-	if err := row.Scan(
-		&u.ID, &u.Email, &u.PasswordHash, &u.GoogleID, &u.CreatedAt, &u.UpdatedAt,
-	); err != nil {
+	u, err := scanUser(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -101,47 +182,37 @@ func (r *UserRepo) GetUserByGoogleID(ctx context.Context, gid string) (*User, er
 	return u, nil
 }
 
-func (r *UserRepo) GetAllUsers(ctx context.Context) ([]*User, error) {
-	rows, err := r.db.QueryContext(ctx, `
-        SELECT id, email, password_hash, google_id, created_at, updated_at FROM users`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var users []*User
-	for rows.Next() {
-		u := &User{}
-		if err := rows.Scan(
-			&u.ID, &u.Email, &u.PasswordHash, &u.GoogleID, &u.CreatedAt, &u.UpdatedAt,
-		); err != nil {
-			return nil, err
-		}
-		users = append(users, u)
-	}
-	return users, nil
-}
+func (r *UserRepo) UpdateUser(ctx context.Context, u *User) (err error) {
+	ctx, span := startSpan(ctx, "UserRepo.UpdateUser", "users")
+	defer func() { endSpan(span, err) }()
 
-func (r *UserRepo) UpdateUser(ctx context.Context, u *User) error {
-	_, err := r.db.ExecContext(ctx, `
-        UPDATE users SET email = $1, password_hash = $2, google_id = $3, updated_at = NOW() WHERE id = $4`,
-		u.Email, u.PasswordHash, u.GoogleID, u.ID)
-	return err
+	_, err = r.db.ExecContext(ctx, `
+        UPDATE users SET email = $1, password_hash = $2, google_id = $3, email_verified_at = $4,
+            status = $5, status_reason = $6, status_expires_at = $7,
+            display_name = $8, avatar_url = $9, avatar_key = $10, locale = $11, timezone = $12, updated_at = NOW()
+        WHERE id = $13`,
+		u.Email, u.PasswordHash, u.GoogleID, u.EmailVerifiedAt, u.Status, u.StatusReason, u.StatusExpiresAt,
+		u.DisplayName, u.AvatarURL, u.AvatarKey, u.Locale, u.Timezone, u.ID)
+	return mapError(err)
 }
 
-func (r *UserRepo) DeleteUser(ctx context.Context, id string) error {
-	_, err := r.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+func (r *UserRepo) DeleteUser(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "UserRepo.DeleteUser", "users")
+	defer func() { endSpan(span, err) }()
+
+	_, err = r.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
 	return err
 }
 
-func (r *UserRepo) GetUserBySessionID(ctx context.Context, sid string) (*User, error) {
+func (r *UserRepo) GetUserBySessionID(ctx context.Context, sid string) (_ *User, err error) {
+	ctx, span := startSpan(ctx, "UserRepo.GetUserBySessionID", "users")
+	defer func() { endSpan(span, err) }()
+
 	row := r.db.QueryRowContext(ctx, `
-        SELECT u.id, u.email, u.password_hash, u.google_id, u.created_at, u.updated_at
-        FROM users u INNER JOIN sessions s ON u.id = s.user_id WHERE s.session_id = $1`, sid)
-	u := &User{}
-	if err := row.Scan(
-		&u.ID, &u.Email, &u.PasswordHash, &u.GoogleID, &u.CreatedAt, &u.UpdatedAt,
-	); err != nil {
+        SELECT `+userColumns+`
+        FROM users WHERE id = (SELECT user_id FROM sessions WHERE id = $1)`, sid)
+	u, err := scanUser(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
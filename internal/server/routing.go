@@ -0,0 +1,241 @@
+// Package server assembles the application's HTTP handler from its
+// services and middleware.
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"template/config"
+	"template/internal/handlers"
+	"template/internal/health"
+	"template/internal/metrics"
+	"template/internal/repository"
+	"template/internal/services"
+	"template/internal/storage"
+)
+
+type HandlerRegistery struct {
+	UserHandler handlers.UserHandler
+	Middleware  *handlers.Middleware
+	Health      *health.Checker
+	logger      *slog.Logger
+	cfg         *config.Config
+}
+
+// NewHandlerRegistery wires the services over the given stores and
+// transactor, which are the Postgres repositories in production and may be
+// fakes in tests.
+func NewHandlerRegistery(stores services.Stores, tx services.Transactor, logger *slog.Logger, m *metrics.Metrics, hc *health.Checker, cfg *config.Config) *HandlerRegistery {
+	as := services.NewAuditService(stores.Audit, logger)
+	ss := services.NewSessionService(stores.Sessions, tx, as)
+	us := services.NewUserService(stores.Users, tx, storage.NewDisk(cfg.Storage.Dir), as)
+	rs := services.NewRoleService(stores.Roles, tx, as)
+	is := services.NewImpersonationService(stores.Impersonations, stores.Roles, tx, as)
+	uh := handlers.UserHandler{
+		US: us, SS: ss, IS: is, RS: rs, AS: as, M: m,
+		DeletionGrace:  cfg.Accounts.DeletionGrace,
+		MaxAvatarBytes: cfg.Accounts.MaxAvatarBytes,
+	}
+	if g := cfg.Google; g.ClientID != "" {
+		uh.Google = handlers.NewGoogleOAuth(g.ClientID, g.ClientSecret, g.RedirectURL)
+	}
+
+	middleware := handlers.NewMiddleware(us, ss, is, rs, m)
+	return &HandlerRegistery{
+		UserHandler: uh,
+		Middleware:  middleware,
+		Health:      hc,
+		logger:      logger,
+		cfg:         cfg,
+	}
+}
+
+func (s *HandlerRegistery) Routes() http.Handler {
+	root := http.NewServeMux()
+
+	s.mountPublicRoutes(root)
+	s.mountProtectedRoutes(root)
+	s.mountAdminRoutes(root)
+	s.mountAPIRoutes(root)
+
+	return s.Middleware.Chain(root,
+		s.Middleware.WithMetrics,
+		s.Middleware.WithTracing,
+		s.Middleware.WithLogging(s.logger),
+		s.Middleware.CompressMiddleware,
+		// Probes come from the orchestrator's few addresses, and a
+		// throttled probe would take the instance out of rotation.
+		s.Middleware.Except(s.Middleware.RateLimitMiddleware, "/healthz", "/readyz"),
+		s.Middleware.SecurityHeaders(handlers.SecurityPolicy{
+			CSP:                       s.cfg.Security.CSP,
+			CSPReportOnly:             s.cfg.Security.CSPReportOnly,
+			ReportURI:                 "/csp-report",
+			HSTS:                      "max-age=31536000; includeSubDomains",
+			ReferrerPolicy:            "strict-origin-when-cross-origin",
+			PermissionsPolicy:         s.cfg.Security.PermissionsPolicy,
+			CrossOriginOpenerPolicy:   s.cfg.Security.COOP,
+			CrossOriginEmbedderPolicy: s.cfg.Security.COEP,
+			CacheControl:              "no-store",
+		}),
+		s.Middleware.BodyLimit(s.cfg.Server.MaxBodyBytes),
+		s.Middleware.RecordRoute(""),
+	)
+}
+
+// timeout bounds a group's handlers by the configured handler timeout.
+// It buffers the response, so streaming routes are mounted outside it.
+func (s *HandlerRegistery) timeout() handlers.Mw {
+	return s.Middleware.Timeout(s.cfg.Server.HandlerTimeout)
+}
+
+func (s *HandlerRegistery) mountPublicRoutes(root *http.ServeMux) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.Health.Liveness)
+	mux.HandleFunc("GET /readyz", s.Health.Readiness)
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "salut // home")
+	})
+	mux.HandleFunc("GET /home", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "salut from home")
+	})
+	mux.Handle("GET /static/", s.Middleware.Chain(
+		http.StripPrefix("/static", http.FileServerFS(handlers.StaticFS)),
+		s.Middleware.CachePolicy("public, max-age=86400"),
+	))
+	mux.HandleFunc("POST /csp-report", handlers.CSPReport(s.logger))
+	// The public forms are protected against CSRF too: their pages issue
+	// the token, which login and register need against login CSRF.
+	csrf := s.Middleware.CSRFMiddleware
+	mux.Handle("GET /login", csrf(http.HandlerFunc(handlers.Home)))
+	mux.Handle("POST /login", csrf(http.HandlerFunc(s.UserHandler.PostLogin)))
+	mux.Handle("GET /register", csrf(http.HandlerFunc(handlers.Home)))
+	mux.Handle("POST /register", csrf(http.HandlerFunc(s.UserHandler.PostRegister)))
+	mux.Handle("POST /logout", csrf(http.HandlerFunc(s.UserHandler.PostLogout)))
+	mux.Handle("POST /account/restore", csrf(http.HandlerFunc(s.UserHandler.RestoreAccount)))
+	if s.UserHandler.Google != nil {
+		// The state cookie does for the callback what the CSRF token does
+		// for the forms.
+		mux.HandleFunc("GET /auth/google/login", s.UserHandler.HandleGoogleLogin)
+		mux.HandleFunc("GET /auth/google/callback", s.UserHandler.LoginWithGoogle)
+	}
+	mux.Handle("GET /avatars/{key}", s.Middleware.Chain(
+		http.HandlerFunc(s.UserHandler.Avatar),
+		s.Middleware.CachePolicy("public, max-age=31536000, immutable"),
+	))
+
+	root.Handle("/", s.Middleware.Chain(mux,
+		s.timeout(),
+		s.Middleware.RecordRoute(""),
+	))
+}
+
+func (s *HandlerRegistery) mountProtectedRoutes(mux *http.ServeMux) {
+	protectedMux := http.NewServeMux()
+	protectedMux.HandleFunc("GET /{$}", s.UserHandler.Dashboard)
+	protectedMux.HandleFunc("GET /dashboard", s.UserHandler.Dashboard)
+	protectedMux.HandleFunc("GET /activity", s.UserHandler.Activity)
+	// An impersonating admin may not change, export or delete the account.
+	deny := s.Middleware.DenyImpersonation
+	protectedMux.Handle("GET /account", deny(http.HandlerFunc(s.UserHandler.Account)))
+	protectedMux.Handle("POST /account/profile", deny(http.HandlerFunc(s.UserHandler.UpdateProfile)))
+	protectedMux.Handle("POST /account/avatar/delete", deny(http.HandlerFunc(s.UserHandler.RemoveAvatar)))
+	protectedMux.Handle("GET /account/delete", deny(http.HandlerFunc(s.UserHandler.DeleteAccountForm)))
+	protectedMux.Handle("POST /account/delete", deny(http.HandlerFunc(s.UserHandler.DeleteAccount)))
+	protectedMux.HandleFunc("POST /impersonation/stop", s.UserHandler.StopImpersonation)
+
+	handler := s.Middleware.Chain(protectedMux,
+		s.timeout(),
+		s.Middleware.AuthMiddleware,
+		s.Middleware.CSRFMiddleware,
+		s.Middleware.SessionRefreshMiddleware,
+		s.Middleware.RecordRoute("/app"),
+	)
+
+	mux.Handle("/app/", http.StripPrefix("/app", handler))
+
+	// Uploads get a larger body limit, which must be in place before
+	// CSRFMiddleware parses the form, so they bypass the group above.
+	mux.Handle("POST /app/account/avatar", s.Middleware.Chain(
+		http.HandlerFunc(s.UserHandler.UploadAvatar),
+		s.timeout(),
+		s.Middleware.BodyLimit(s.cfg.Accounts.MaxAvatarBytes),
+		s.Middleware.AuthMiddleware,
+		s.Middleware.CSRFMiddleware,
+		s.Middleware.SessionRefreshMiddleware,
+		s.Middleware.DenyImpersonation,
+	))
+
+	// The export is streamed, which the timeout's buffering would defeat.
+	mux.Handle("GET /app/account/export", s.Middleware.Chain(
+		http.HandlerFunc(s.UserHandler.ExportAccount),
+		s.Middleware.AuthMiddleware,
+		s.Middleware.CSRFMiddleware,
+		s.Middleware.SessionRefreshMiddleware,
+		s.Middleware.DenyImpersonation,
+	))
+}
+
+// mountAdminRoutes mounts the back office under /admin. User pages need
+// users:read, actions users:write, impersonation users:impersonate and the
+// audit log audit:read, all held by the admin role.
+func (s *HandlerRegistery) mountAdminRoutes(mux *http.ServeMux) {
+	read := s.Middleware.RequirePermission(repository.PermUsersRead)
+	write := s.Middleware.RequirePermission(repository.PermUsersWrite)
+	impersonate := s.Middleware.RequirePermission(repository.PermUsersImpersonate)
+	audit := s.Middleware.RequirePermission(repository.PermAuditRead)
+	uh := &s.UserHandler
+
+	adminMux := http.NewServeMux()
+	adminMux.Handle("GET /{$}", read(http.RedirectHandler("/admin/users", http.StatusSeeOther)))
+	adminMux.Handle("GET /users", read(http.HandlerFunc(uh.AdminUsers)))
+	adminMux.Handle("GET /users/{id}", read(http.HandlerFunc(uh.AdminUser)))
+	adminMux.Handle("POST /users/{id}/sessions/revoke", write(http.HandlerFunc(uh.AdminRevokeSessions)))
+	adminMux.Handle("POST /users/{id}/password", write(http.HandlerFunc(uh.AdminResetPassword)))
+	adminMux.Handle("POST /users/{id}/password/clear", write(http.HandlerFunc(uh.AdminClearPassword)))
+	adminMux.Handle("POST /users/{id}/verify", write(http.HandlerFunc(uh.AdminVerifyEmail)))
+	adminMux.Handle("POST /users/{id}/suspend", write(http.HandlerFunc(uh.AdminSuspend)))
+	adminMux.Handle("POST /users/{id}/ban", write(http.HandlerFunc(uh.AdminBan)))
+	adminMux.Handle("POST /users/{id}/reactivate", write(http.HandlerFunc(uh.AdminReactivate)))
+	adminMux.Handle("POST /users/{id}/delete", write(http.HandlerFunc(uh.AdminDelete)))
+	adminMux.Handle("POST /users/{id}/impersonate", impersonate(http.HandlerFunc(uh.AdminImpersonate)))
+	adminMux.Handle("GET /audit", audit(http.HandlerFunc(uh.AdminAudit)))
+
+	handler := s.Middleware.Chain(adminMux,
+		s.timeout(),
+		s.Middleware.AuthMiddleware,
+		s.Middleware.CSRFMiddleware,
+		s.Middleware.SessionRefreshMiddleware,
+		s.Middleware.RecordRoute("/admin"),
+	)
+
+	mux.Handle("/admin/", http.StripPrefix("/admin", handler))
+}
+
+// mountAPIRoutes mounts the JSON API, which may be called from the
+// origins listed in the CORS config.
+func (s *HandlerRegistery) mountAPIRoutes(mux *http.ServeMux) {
+	apiMux := http.NewServeMux()
+	apiMux.Handle("GET /users", s.Middleware.Chain(
+		http.HandlerFunc(s.UserHandler.ListUsersJSON),
+		s.Middleware.APIAuthMiddleware,
+		s.Middleware.APIRequirePermission(repository.PermUsersRead),
+	))
+
+	handler := s.Middleware.Chain(apiMux,
+		s.timeout(),
+		s.Middleware.CORS(handlers.CORSOptions{
+			AllowedOrigins:   s.cfg.CORS.AllowedOrigins,
+			AllowedMethods:   []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+			AllowedHeaders:   s.cfg.CORS.AllowedHeaders,
+			ExposedHeaders:   []string{"X-Request-ID"},
+			AllowCredentials: s.cfg.CORS.AllowCredentials,
+			MaxAge:           time.Duration(s.cfg.CORS.MaxAge) * time.Second,
+		}),
+		s.Middleware.RecordRoute("/api"),
+	)
+
+	mux.Handle("/api/", http.StripPrefix("/api", handler))
+}
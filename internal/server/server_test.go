@@ -0,0 +1,545 @@
+package server_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"template/internal/repository"
+	"template/internal/services"
+	"template/internal/testutil"
+)
+
+func TestRegisterDashboardLogout(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		c := app.Client(t)
+
+		resp := c.Register("ada@example.com", "correct horse")
+		resp.AssertStatus(t, http.StatusOK)
+		resp.AssertPath(t, "/app/")
+		testutil.Golden(t, "dashboard", resp.Body)
+
+		resp = c.Logout()
+		resp.AssertPath(t, "/login")
+		if c.Cookie("session") != "" {
+			t.Error("session cookie kept after logout")
+		}
+
+		resp = c.Get("/app/dashboard")
+		resp.AssertPath(t, "/login")
+	})
+}
+
+func TestLogin(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		app.Client(t).Register("ada@example.com", "correct horse").AssertPath(t, "/app/")
+
+		c := app.Client(t)
+		c.Login("ada@example.com", "wrong password").AssertStatus(t, http.StatusUnauthorized)
+		c.Login("nobody@example.com", "correct horse").AssertStatus(t, http.StatusUnauthorized)
+		c.Login("ada@example.com", "short").AssertStatus(t, http.StatusUnprocessableEntity)
+
+		resp := c.Login("ADA@example.com ", "correct horse")
+		resp.AssertPath(t, "/app/")
+		resp.AssertContains(t, "ada@example.com")
+	})
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		app.Client(t).Register("ada@example.com", "correct horse").AssertStatus(t, http.StatusOK)
+		app.Client(t).Register("ada@example.com", "other horse!").AssertStatus(t, http.StatusConflict)
+	})
+}
+
+func TestProtectedRequiresSession(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		app.Client(t).Get("/app/dashboard").AssertPath(t, "/login")
+
+		c, user := app.ActAs(t, "grace@example.com")
+		resp := c.Get("/app/dashboard")
+		resp.AssertStatus(t, http.StatusOK)
+		resp.AssertContains(t, user.Email)
+
+		// Deleting the user signs every client out.
+		if err := app.Users.Delete(t.Context(), user); err != nil {
+			t.Fatal(err)
+		}
+		c.Get("/app/dashboard").AssertPath(t, "/login")
+	})
+}
+
+func TestCSRF(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		c, _ := app.ActAs(t, "grace@example.com")
+
+		// Posting before any page has issued a token is refused.
+		c.PostForm("/app/dashboard", nil).AssertStatus(t, http.StatusForbidden)
+
+		c.Get("/app/dashboard").AssertStatus(t, http.StatusOK)
+		if c.Cookie("csrf_token") == "" {
+			t.Fatal("no csrf_token cookie issued")
+		}
+		c.PostForm("/app/dashboard", url.Values{"csrf_token": {"forged"}}).AssertStatus(t, http.StatusForbidden)
+		// With the token the request reaches the mux, which only serves GET.
+		c.PostForm("/app/dashboard", nil).AssertStatus(t, http.StatusMethodNotAllowed)
+	})
+}
+
+func TestPublicFormsCSRF(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		app.Client(t).Register("ada@example.com", "correct horse").AssertPath(t, "/app/")
+
+		// A cross-site form post carries no token and is refused.
+		c := app.Client(t)
+		creds := url.Values{"email": {"ada@example.com"}, "password": {"correct horse"}, "repeat": {"correct horse"}}
+		for _, path := range []string{"/login", "/register", "/logout", "/account/restore"} {
+			c.PostForm(path, creds).AssertStatus(t, http.StatusForbidden)
+		}
+		if c.Cookie("session") != "" {
+			t.Error("session created without a CSRF token")
+		}
+
+		c.Get("/login").AssertStatus(t, http.StatusOK)
+		c.PostForm("/login", url.Values{"email": {"ada@example.com"}, "password": {"correct horse"}, "csrf_token": {"forged"}}).
+			AssertStatus(t, http.StatusForbidden)
+		c.Login("ada@example.com", "correct horse").AssertPath(t, "/app/")
+	})
+}
+
+func TestListUsers(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		app.Client(t).Get("/api/users").AssertStatus(t, http.StatusUnauthorized)
+
+		c, admin := app.ActAs(t, "admin@example.com")
+		if err := app.Roles.Assign(t.Context(), admin, repository.RoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+		for i := range 3 {
+			if _, err := app.Users.Create(t.Context(), repository.User{Email: fmt.Sprintf("user%d@example.com", i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var emails []string
+		path := "/api/users?q=user&sort=email&limit=2"
+		for path != "" {
+			resp := c.Get(path)
+			resp.AssertStatus(t, http.StatusOK)
+			var page struct {
+				Users []struct {
+					Email string `json:"email"`
+				} `json:"users"`
+				Total      int    `json:"total"`
+				NextCursor string `json:"next_cursor"`
+			}
+			if err := json.Unmarshal([]byte(resp.Body), &page); err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != 3 {
+				t.Errorf("total = %d, want 3", page.Total)
+			}
+			for _, u := range page.Users {
+				emails = append(emails, u.Email)
+			}
+			path = ""
+			if page.NextCursor != "" {
+				path = "/api/users?q=user&sort=email&limit=2&cursor=" + url.QueryEscape(page.NextCursor)
+			}
+		}
+		if fmt.Sprint(emails) != "[user0@example.com user1@example.com user2@example.com]" {
+			t.Errorf("emails = %v", emails)
+		}
+
+		c.Get("/api/users?cursor=bogus").AssertStatus(t, http.StatusBadRequest)
+		c.Get("/api/users?verified=maybe").AssertStatus(t, http.StatusBadRequest)
+
+		resp := c.Get("/admin/users?sort=email&limit=1")
+		resp.AssertStatus(t, http.StatusOK)
+		resp.AssertContains(t, "4 users")
+		resp.AssertContains(t, "admin@example.com")
+		resp.AssertContains(t, "Next page")
+	})
+}
+
+func TestRequirePermission(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		c, user := app.ActAs(t, "grace@example.com")
+		c.Get("/admin/users").AssertStatus(t, http.StatusForbidden)
+		c.Get("/api/users").AssertStatus(t, http.StatusForbidden)
+		if resp := c.Get("/app/dashboard"); strings.Contains(resp.Body, "/admin/users") {
+			t.Error("dashboard links to the users page without users:read")
+		}
+
+		if err := app.Roles.Assign(t.Context(), user, repository.RoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+		c.Get("/admin/users").AssertStatus(t, http.StatusOK)
+		c.Get("/api/users").AssertStatus(t, http.StatusOK)
+		c.Get("/app/dashboard").AssertContains(t, `href="/admin/users"`)
+
+		if err := app.Roles.Assign(t.Context(), user, "no-such-role"); !errors.Is(err, services.ErrUnknownRole) {
+			t.Errorf("assign unknown role: err = %v, want ErrUnknownRole", err)
+		}
+	})
+}
+
+func TestAdminConsole(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		ctx := t.Context()
+		admin, adminUser := app.ActAs(t, "admin@example.com")
+		if err := app.Roles.Assign(ctx, adminUser, repository.RoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+		app.Client(t).Register("ada@example.com", "correct horse").AssertPath(t, "/app/")
+		ada, err := app.Users.GetByEmail(ctx, "ada@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := "/admin/users/" + ada.ID
+
+		resp := admin.Get(page)
+		resp.AssertStatus(t, http.StatusOK)
+		resp.AssertContains(t, "ada@example.com")
+		admin.PostForm(page+"/verify", nil).AssertPath(t, page)
+
+		// A suspended user is signed out and cannot sign back in.
+		c := app.Client(t)
+		c.Login("ada@example.com", "correct horse").AssertPath(t, "/app/")
+		admin.PostForm(page+"/suspend", url.Values{"reason": {"spam"}}).AssertPath(t, page)
+		c.Get("/app/dashboard").AssertPath(t, "/login")
+		resp = c.Login("ada@example.com", "correct horse")
+		resp.AssertStatus(t, http.StatusForbidden)
+		resp.AssertContains(t, "Your account is suspended")
+		resp.AssertContains(t, "Reason: spam")
+		admin.PostForm(page+"/reactivate", nil).AssertPath(t, page)
+
+		admin.PostForm(page+"/password", url.Values{"password": {"short"}}).AssertStatus(t, http.StatusUnprocessableEntity)
+		admin.PostForm(page+"/password", url.Values{"password": {"battery staple"}}).AssertPath(t, page)
+		c.Login("ada@example.com", "battery staple").AssertPath(t, "/app/")
+		admin.PostForm(page+"/sessions/revoke", nil).AssertPath(t, page)
+		c.Get("/app/dashboard").AssertPath(t, "/login")
+
+		admin.PostForm("/admin/users/"+adminUser.ID+"/delete", nil).AssertStatus(t, http.StatusConflict)
+		admin.PostForm(page+"/delete", nil).AssertPath(t, "/admin/users")
+		admin.Get(page).AssertStatus(t, http.StatusNotFound)
+
+		events, err := app.Audit.List(ctx, repository.AuditFilter{ActorID: adminUser.ID, TargetUserID: ada.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range events {
+			if e.RequestID == "" || e.IPAddress == nil {
+				t.Errorf("event %+v", e)
+			}
+			got = append(got, e.Type)
+		}
+		want := []string{
+			services.EventUserDeleted,
+			services.EventSessionsRevoked,
+			services.EventPasswordChanged,
+			services.EventUserReactivated,
+			services.EventUserSuspended,
+			services.EventEmailVerified,
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("audit trail = %v, want %v", got, want)
+		}
+
+		// Reading does not allow acting.
+		viewer, _ := app.ActAs(t, "viewer@example.com")
+		viewer.Get("/admin/").AssertStatus(t, http.StatusForbidden)
+	})
+}
+
+func TestLapsedSuspension(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		ctx := t.Context()
+		admin, adminUser := app.ActAs(t, "admin@example.com")
+		if err := app.Roles.Assign(ctx, adminUser, repository.RoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+		app.Client(t).Register("ada@example.com", "correct horse").AssertPath(t, "/app/")
+		ada, err := app.Users.GetByEmail(ctx, "ada@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := app.Users.Suspend(ctx, ada, "cooling off", time.Now().Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		// The suspension no longer stops the user, and the console says so.
+		app.Client(t).Login("ada@example.com", "correct horse").AssertPath(t, "/app/")
+		admin.Get("/admin/users/"+ada.ID).AssertContains(t, "suspended, expired")
+		admin.Get("/admin/users?q=ada").AssertContains(t, "suspended (expired)")
+	})
+}
+
+func TestAuditTrail(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		ctx := t.Context()
+		c := app.Client(t)
+		c.Register("ada@example.com", "correct horse").AssertPath(t, "/app/")
+		c.Logout().AssertPath(t, "/login")
+		c.Login("ada@example.com", "wrong horse").AssertStatus(t, http.StatusUnauthorized)
+		c.Login("nobody@example.com", "wrong horse").AssertStatus(t, http.StatusUnauthorized)
+		c.Login("ada@example.com", "correct horse").AssertPath(t, "/app/")
+
+		ada, err := app.Users.GetByEmail(ctx, "ada@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		events, err := app.Audit.List(ctx, repository.AuditFilter{TargetUserID: ada.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range events {
+			got = append(got, e.Type)
+			if e.Type != services.EventLoginFailed && e.ActorID.String != ada.ID {
+				t.Errorf("%s: actor = %q, want the user", e.Type, e.ActorID.String)
+			}
+		}
+		want := []string{
+			services.EventLoginSucceeded,
+			services.EventLoginFailed,
+			services.EventLogout,
+			services.EventUserRegistered,
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("audit trail = %v, want %v", got, want)
+		}
+		if reason := events[1].Metadata["reason"]; reason != "invalid_password" {
+			t.Errorf("failed login reason = %q", reason)
+		}
+
+		unknown, err := app.Audit.List(ctx, repository.AuditFilter{Type: services.EventLoginFailed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(unknown) != 2 || unknown[0].TargetUserID.Valid || unknown[0].Metadata["email"] != "nobody@example.com" {
+			t.Errorf("failed logins = %+v", unknown)
+		}
+
+		resp := c.Get("/app/activity")
+		resp.AssertStatus(t, http.StatusOK)
+		resp.AssertContains(t, services.EventUserRegistered)
+		c.Get("/admin/audit").AssertStatus(t, http.StatusForbidden)
+
+		admin, adminUser := app.ActAs(t, "admin@example.com")
+		if err := app.Roles.Assign(ctx, adminUser, repository.RoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+		resp = admin.Get("/admin/audit?type=login.failed")
+		resp.AssertStatus(t, http.StatusOK)
+		resp.AssertContains(t, "nobody@example.com")
+		admin.Get("/admin/audit?user="+ada.ID).AssertContains(t, services.EventLogout)
+		admin.Get("/admin/audit?actor=nope").AssertStatus(t, http.StatusBadRequest)
+		admin.Get("/admin/audit?before=x").AssertStatus(t, http.StatusBadRequest)
+	})
+}
+
+func TestImpersonation(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		ctx := t.Context()
+		admin, adminUser := app.ActAs(t, "admin@example.com")
+		if err := app.Roles.Assign(ctx, adminUser, repository.RoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+		_, ada := app.ActAs(t, "ada@example.com")
+		page := "/admin/users/" + ada.ID
+
+		admin.Get(page).AssertContains(t, page+"/impersonate")
+		admin.PostForm("/admin/users/"+adminUser.ID+"/impersonate", nil).AssertStatus(t, http.StatusConflict)
+
+		resp := admin.PostForm(page+"/impersonate", nil)
+		resp.AssertPath(t, "/app/")
+		resp.AssertContains(t, "Signed in as <strong>ada@example.com</strong>")
+		resp.AssertContains(t, "You (admin@example.com) are impersonating <strong>ada@example.com</strong>")
+		admin.Get("/app/account").AssertStatus(t, http.StatusForbidden)
+		// The admin pages stay the admin's own.
+		admin.Get("/admin/users").AssertStatus(t, http.StatusOK)
+
+		resp = admin.PostForm("/app/impersonation/stop", nil)
+		resp.AssertPath(t, page)
+		resp = admin.Get("/app/dashboard")
+		resp.AssertContains(t, "Signed in as <strong>admin@example.com</strong>")
+		if strings.Contains(resp.Body, "impersonating") {
+			t.Error("banner shown after stopping")
+		}
+
+		events, err := app.Audit.List(ctx, repository.AuditFilter{ActorID: adminUser.ID, TargetUserID: ada.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range events {
+			got = append(got, e.Type)
+		}
+		want := []string{services.EventImpersonationStopped, services.EventImpersonationStarted}
+		if !slices.Equal(got, want) {
+			t.Errorf("audit trail = %v, want %v", got, want)
+		}
+
+		// Users without users:impersonate cannot start one.
+		viewer, _ := app.ActAs(t, "viewer@example.com")
+		viewer.PostForm(page+"/impersonate", nil).AssertStatus(t, http.StatusForbidden)
+	})
+}
+
+func TestInactiveAccountPage(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		c, user := app.ActAs(t, "grace@example.com")
+		c.Get("/app/dashboard").AssertStatus(t, http.StatusOK)
+
+		// A status set behind the services' back still ends the session,
+		// with an explanation rather than a bare redirect.
+		user.Status = repository.StatusBanned
+		user.StatusReason = "fraud"
+		if err := app.Users.UR.UpdateUser(t.Context(), user); err != nil {
+			t.Fatal(err)
+		}
+		resp := c.Get("/app/dashboard")
+		resp.AssertStatus(t, http.StatusForbidden)
+		resp.AssertContains(t, "Your account has been banned")
+		resp.AssertContains(t, "Reason: fraud")
+		c.Get("/app/dashboard").AssertPath(t, "/login")
+	})
+}
+
+func TestAccountDeletion(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		ctx := t.Context()
+		c := app.Client(t)
+		c.Register("ada@example.com", "correct horse").AssertPath(t, "/app/")
+
+		resp := c.Get("/app/account/export")
+		resp.AssertStatus(t, http.StatusOK)
+		if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, "attachment") {
+			t.Errorf("Content-Disposition = %q", cd)
+		}
+		var export services.AccountExport
+		if err := json.Unmarshal([]byte(resp.Body), &export); err != nil {
+			t.Fatal(err)
+		}
+		if export.Profile.Email != "ada@example.com" || len(export.Sessions) != 1 || len(export.AuditEvents) == 0 {
+			t.Errorf("export = %+v", export)
+		}
+		resp = c.Get("/app/account/export?format=zip")
+		resp.AssertStatus(t, http.StatusOK)
+		if ct := resp.Header.Get("Content-Type"); ct != "application/zip" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		if zr, err := zip.NewReader(strings.NewReader(resp.Body), int64(len(resp.Body))); err != nil || len(zr.File) != 5 {
+			t.Errorf("zip export: %v", err)
+		}
+		c.Get("/app/account/export?format=xml").AssertStatus(t, http.StatusBadRequest)
+
+		c.PostForm("/app/account/delete", url.Values{"password": {"wrong horse"}}).AssertStatus(t, http.StatusUnauthorized)
+		resp = c.PostForm("/app/account/delete", url.Values{"password": {"correct horse"}})
+		resp.AssertStatus(t, http.StatusOK)
+		resp.AssertContains(t, "Your account is being deleted")
+		c.Get("/app/dashboard").AssertPath(t, "/login")
+
+		resp = c.Login("ada@example.com", "correct horse")
+		resp.AssertStatus(t, http.StatusForbidden)
+		resp.AssertContains(t, `action="/account/restore"`)
+
+		c.PostForm("/account/restore", url.Values{"email": {"ada@example.com"}, "password": {"wrong horse"}}).AssertStatus(t, http.StatusUnauthorized)
+		resp = c.PostForm("/account/restore", url.Values{"email": {"ada@example.com"}, "password": {"correct horse"}})
+		resp.AssertPath(t, "/app/")
+		resp.AssertContains(t, "Signed in as <strong>ada@example.com</strong>")
+
+		// Accounts past their grace period are purged.
+		user, err := app.Users.GetByEmail(ctx, "ada@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := app.Users.RequestDeletion(ctx, user, 0); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := app.Users.PurgeDeleted(ctx); err != nil || n != 1 {
+			t.Fatalf("PurgeDeleted = %d, %v", n, err)
+		}
+		if user, err := app.Users.GetByEmail(ctx, "ada@example.com"); err != nil || user != nil {
+			t.Errorf("user after purge = %v, %v", user, err)
+		}
+	})
+}
+
+func TestProfile(t *testing.T) {
+	testutil.Run(t, func(t *testing.T, app *testutil.App) {
+		c := app.Client(t)
+		c.Register("ada@example.com", "correct horse").AssertPath(t, "/app/")
+
+		resp := c.PostForm("/app/account/profile", url.Values{"display_name": {"Ada"}, "timezone": {"Nowhere/Special"}})
+		resp.AssertStatus(t, http.StatusUnprocessableEntity)
+		resp.AssertContains(t, services.ErrInvalidTimezone.Error())
+		resp.AssertContains(t, `value="Nowhere/Special"`)
+
+		resp = c.PostForm("/app/account/profile", url.Values{"display_name": {"Ada"}, "locale": {"en-gb"}, "timezone": {"Europe/London"}})
+		resp.AssertPath(t, "/app/account")
+		resp.AssertContains(t, `value="en-GB"`)
+		c.Get("/app/dashboard").AssertContains(t, "<strong>ada@example.com</strong> (Ada)")
+
+		img := image.NewRGBA(image.Rect(0, 0, 512, 512))
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		c.Upload("/app/account/avatar", "avatar", "ada.txt", []byte("not an image")).AssertStatus(t, http.StatusUnprocessableEntity)
+		c.Upload("/app/account/avatar", "avatar", "ada.png", make([]byte, app.Config.Accounts.MaxAvatarBytes+1)).
+			AssertStatus(t, http.StatusRequestEntityTooLarge)
+		resp = c.Upload("/app/account/avatar", "avatar", "ada.png", buf.Bytes())
+		resp.AssertPath(t, "/app/account")
+
+		user, err := app.Users.GetByEmail(t.Context(), "ada@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := "/avatars/" + user.AvatarKey
+		resp.AssertContains(t, `src="`+src+`"`)
+		resp = app.Client(t).Get(src)
+		resp.AssertStatus(t, http.StatusOK)
+		if ct, cc := resp.Header.Get("Content-Type"), resp.Header.Get("Cache-Control"); ct != "image/png" || !strings.Contains(cc, "immutable") {
+			t.Errorf("avatar served as %q with Cache-Control %q", ct, cc)
+		}
+		app.Client(t).Get("/avatars/missing.png").AssertStatus(t, http.StatusNotFound)
+
+		resp = c.PostForm("/app/account/avatar/delete", nil)
+		resp.AssertPath(t, "/app/account")
+		if strings.Contains(resp.Body, src) {
+			t.Error("avatar shown after removal")
+		}
+		app.Client(t).Get(src).AssertStatus(t, http.StatusNotFound)
+	})
+}
+
+func TestProbesNotRateLimited(t *testing.T) {
+	app := testutil.New(t, testutil.Memory)
+	c := app.Client(t)
+
+	for range 120 {
+		c.Get("/readyz").AssertStatus(t, http.StatusOK)
+	}
+	for range 120 {
+		c.Get("/healthz").AssertStatus(t, http.StatusOK)
+	}
+	// The probes did not use up the client's allowance.
+	for range 100 {
+		c.Get("/home").AssertStatus(t, http.StatusOK)
+	}
+	c.Get("/home").AssertStatus(t, http.StatusTooManyRequests)
+	c.Get("/readyz").AssertStatus(t, http.StatusOK)
+}
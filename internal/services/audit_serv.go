@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"maps"
+	"net"
+	"time"
+
+	"template/internal/repository"
+)
+
+// Audit event types.
+const (
+	EventUserRegistered  = "user.registered"
+	EventUserCreated     = "user.created"
+	EventUserDeleted     = "user.deleted"
+	EventAccountExported = "user.exported"
+
+	EventDeletionRequested = "user.deletion_requested"
+	EventDeletionCancelled = "user.deletion_cancelled"
+	EventLoginSucceeded    = "login.succeeded"
+	EventLoginFailed       = "login.failed"
+	EventLogout            = "logout"
+	EventPasswordChanged   = "password.changed"
+	EventPasswordCleared   = "password.cleared"
+	EventEmailVerified     = "email.verified"
+	EventSessionsRevoked   = "sessions.revoked"
+	EventIdentityLinked    = "identity.linked"
+	EventUserSuspended     = "user.suspended"
+	EventUserBanned        = "user.banned"
+	EventUserReactivated   = "user.reactivated"
+	EventRoleAssigned      = "role.assigned"
+	EventRoleUnassigned    = "role.unassigned"
+
+	EventImpersonationStarted = "impersonation.started"
+	EventImpersonationStopped = "impersonation.stopped"
+
+	EventProfileUpdated = "profile.updated"
+	EventAvatarChanged  = "avatar.changed"
+	EventAvatarRemoved  = "avatar.removed"
+)
+
+// DefaultAuditLimit is the number of events List returns when the filter
+// sets no limit.
+const DefaultAuditLimit = 50
+
+// RequestInfo says who is acting and from where. The handlers attach it to
+// the context of each request, and the audit trail reads it back.
+type RequestInfo struct {
+	// ActorID is the signed-in user, if any. When an admin impersonates a
+	// user, it is the admin and Impersonating the user.
+	ActorID       string
+	Impersonating string
+	IPAddress     net.IP
+	UserAgent     string
+	RequestID     string
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns ctx carrying info.
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFrom returns the RequestInfo attached to ctx, or the zero
+// value outside a request, as in the CLI.
+func RequestInfoFrom(ctx context.Context) RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info
+}
+
+// asActor returns ctx with user as the actor, for events whose actor the
+// service knows better than the request does: a user signing in, or the
+// admin starting an impersonation.
+func asActor(ctx context.Context, user *repository.User) context.Context {
+	info := RequestInfoFrom(ctx)
+	info.ActorID = user.ID
+	return WithRequestInfo(ctx, info)
+}
+
+type AuditService struct {
+	repo   AuditStore
+	logger *slog.Logger
+}
+
+func NewAuditService(repo AuditStore, logger *slog.Logger) *AuditService {
+	return &AuditService{repo: repo, logger: logger}
+}
+
+// Record appends e to the audit trail. The actor, IP address, user agent
+// and request ID that e leaves empty are taken from the context's
+// RequestInfo, which also marks events happening during an impersonation.
+func (as *AuditService) Record(ctx context.Context, e *repository.AuditEvent) (err error) {
+	ctx, span := startSpan(ctx, "AuditService.Record")
+	defer func() { endSpan(span, err) }()
+
+	return as.write(ctx, as.repo, e)
+}
+
+// write completes e from the context like Record, and appends it through
+// store.
+func (as *AuditService) write(ctx context.Context, store AuditStore, e *repository.AuditEvent) error {
+	info := RequestInfoFrom(ctx)
+	if !e.ActorID.Valid && info.ActorID != "" {
+		e.ActorID = sql.NullString{String: info.ActorID, Valid: true}
+	}
+	if e.IPAddress == nil {
+		e.IPAddress = info.IPAddress
+	}
+	if e.UserAgent == "" {
+		e.UserAgent = info.UserAgent
+	}
+	if e.RequestID == "" {
+		e.RequestID = info.RequestID
+	}
+	if info.Impersonating != "" {
+		e.Metadata = maps.Clone(e.Metadata)
+		if e.Metadata == nil {
+			e.Metadata = make(map[string]string)
+		}
+		e.Metadata["impersonating"] = info.Impersonating
+	}
+	return store.RecordAuditEvent(ctx, e)
+}
+
+// record is how the services report what they did. It writes through
+// store, the audit store of the unit of work that performs the action, so
+// that the action and its event are kept or discarded together. The
+// target is referred to by ID only: events outlive the accounts they
+// mention, and must not keep a deleted user's email. A nil AuditService
+// records nothing.
+func (as *AuditService) record(ctx context.Context, store AuditStore, typ string, target *repository.User, metadata map[string]string) error {
+	if as == nil {
+		return nil
+	}
+	e := &repository.AuditEvent{Type: typ, Metadata: maps.Clone(metadata)}
+	if target != nil {
+		e.TargetUserID = sql.NullString{String: target.ID, Valid: target.ID != ""}
+	}
+	return as.write(ctx, store, e)
+}
+
+// recordAttempt records an event that comes with no write of its own,
+// such as a failed sign-in. There is nothing to undo, so a failure is
+// logged rather than returned.
+func (as *AuditService) recordAttempt(ctx context.Context, typ string, target *repository.User, metadata map[string]string) {
+	if as == nil {
+		return
+	}
+	if err := as.record(ctx, as.repo, typ, target, metadata); err != nil {
+		as.logger.ErrorContext(ctx, "audit event not recorded", slog.String("type", typ), slog.String("error", err.Error()))
+	}
+}
+
+// List returns the events matching f, newest first.
+func (as *AuditService) List(ctx context.Context, f repository.AuditFilter) (_ []repository.AuditEvent, err error) {
+	ctx, span := startSpan(ctx, "AuditService.List")
+	defer func() { endSpan(span, err) }()
+
+	if f.Limit <= 0 {
+		f.Limit = DefaultAuditLimit
+	}
+	return as.repo.ListAuditEvents(ctx, f)
+}
+
+// Purge deletes the events older than retention and reports how many were
+// removed. A zero retention keeps every event.
+func (as *AuditService) Purge(ctx context.Context, retention time.Duration) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "AuditService.Purge")
+	defer func() { endSpan(span, err) }()
+
+	if retention <= 0 {
+		return 0, nil
+	}
+	return as.repo.DeleteAuditEventsBefore(ctx, time.Now().Add(-retention))
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"template/internal/repository"
+)
+
+// AccountExport is everything the app holds about a user, in the form
+// they download it.
+type AccountExport struct {
+	ExportedAt  time.Time            `json:"exported_at"`
+	Profile     ExportedProfile      `json:"profile"`
+	Identities  []ExportedIdentity   `json:"identities"`
+	Roles       []string             `json:"roles"`
+	Sessions    []ExportedSession    `json:"sessions"`
+	AuditEvents []ExportedAuditEvent `json:"audit_events"`
+}
+
+type ExportedProfile struct {
+	ID              string     `json:"id"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Status          string     `json:"status"`
+	DisplayName     string     `json:"display_name"`
+	Locale          string     `json:"locale"`
+	Timezone        string     `json:"timezone"`
+	// AvatarURL is the identity provider's picture; an uploaded avatar
+	// is not part of the document.
+	AvatarURL string    `json:"avatar_url"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ExportedIdentity is a way the user signs in. The password itself is
+// never exported, only that there is one.
+type ExportedIdentity struct {
+	Provider string `json:"provider"`
+	// Subject is the provider's ID for the user, if any.
+	Subject string `json:"subject,omitempty"`
+}
+
+type ExportedSession struct {
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	IPAddress string     `json:"ip_address"`
+	UserAgent string     `json:"user_agent"`
+}
+
+// ExportedAuditEvent is an event of the user's history. The IP address and
+// user agent are only kept for the user's own actions, not for staff's.
+type ExportedAuditEvent struct {
+	Type      string            `json:"type"`
+	ByYou     bool              `json:"by_you"`
+	IPAddress string            `json:"ip_address,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// exportBatch is how many audit events Export reads at a time.
+const exportBatch = 500
+
+// Export gathers the user's data in one unit of work, so that the parts of
+// the archive agree with each other.
+func (us *UserService) Export(ctx context.Context, user *repository.User) (_ *AccountExport, err error) {
+	ctx, span := startSpan(ctx, "UserService.Export")
+	defer func() { endSpan(span, err) }()
+
+	export := &AccountExport{
+		ExportedAt: time.Now().UTC(),
+		Identities: []ExportedIdentity{},
+		Sessions:   []ExportedSession{},
+	}
+	err = us.tx.WithTx(ctx, func(ctx context.Context, tx Stores) error {
+		u, err := tx.Users.GetUserByID(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return ErrUserNotFound
+		}
+		export.Profile = ExportedProfile{
+			ID:          u.ID,
+			Email:       u.Email,
+			Status:      u.Status,
+			DisplayName: u.DisplayName,
+			Locale:      u.Locale,
+			Timezone:    u.Timezone,
+			AvatarURL:   u.AvatarURL,
+			CreatedAt:   u.CreatedAt,
+			UpdatedAt:   u.UpdatedAt,
+		}
+		if u.EmailVerifiedAt.Valid {
+			export.Profile.EmailVerifiedAt = &u.EmailVerifiedAt.Time
+		}
+		if u.PasswordHash.Valid {
+			export.Identities = append(export.Identities, ExportedIdentity{Provider: "password"})
+		}
+		if u.GoogleID.Valid {
+			export.Identities = append(export.Identities, ExportedIdentity{Provider: "google", Subject: u.GoogleID.String})
+		}
+
+		if export.Roles, err = tx.Roles.UserRoles(ctx, u.ID); err != nil {
+			return err
+		}
+		if export.Roles == nil {
+			export.Roles = []string{}
+		}
+
+		sessions, err := tx.Sessions.ListByUserID(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+		for _, s := range sessions {
+			export.Sessions = append(export.Sessions, ExportedSession{
+				CreatedAt: s.CreatedAt,
+				ExpiresAt: s.ExpiresAt,
+				IPAddress: ipString(s.IPAddress),
+				UserAgent: s.UserAgent,
+			})
+		}
+
+		export.AuditEvents = []ExportedAuditEvent{}
+		f := repository.AuditFilter{TargetUserID: u.ID, Limit: exportBatch}
+		for {
+			events, err := tx.Audit.ListAuditEvents(ctx, f)
+			if err != nil {
+				return err
+			}
+			for _, e := range events {
+				exported := ExportedAuditEvent{
+					Type:      e.Type,
+					ByYou:     e.ActorID.String == u.ID,
+					Metadata:  e.Metadata,
+					CreatedAt: e.CreatedAt,
+				}
+				if exported.ByYou {
+					exported.IPAddress, exported.UserAgent = ipString(e.IPAddress), e.UserAgent
+				}
+				export.AuditEvents = append(export.AuditEvents, exported)
+			}
+			if len(events) < exportBatch {
+				break
+			}
+			f.Before = events[len(events)-1].ID
+		}
+		return us.audit.record(ctx, tx.Audit, EventAccountExported, user, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
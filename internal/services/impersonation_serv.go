@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"template/internal/repository"
+)
+
+// ImpersonationDuration is how long an impersonation lasts. Unlike a
+// session, it is not extended by activity.
+const ImpersonationDuration = 15 * time.Minute
+
+var (
+	ErrCannotImpersonate = errors.New("cannot impersonate this user")
+	ErrNotImpersonating  = errors.New("not impersonating")
+)
+
+type ImpersonationService struct {
+	repo  ImpersonationStore
+	roles RoleStore
+	tx    Transactor
+	audit *AuditService
+}
+
+// NewImpersonationService returns an ImpersonationService recording
+// impersonations to audit, which may be nil, in the unit of work of tx
+// that starts or stops them. roles tells staff apart, who cannot be
+// impersonated.
+func NewImpersonationService(repo ImpersonationStore, roles RoleStore, tx Transactor, audit *AuditService) *ImpersonationService {
+	return &ImpersonationService{repo: repo, roles: roles, tx: tx, audit: audit}
+}
+
+// Start lets admin use the app as target for ImpersonationDuration and
+// returns the impersonation cookie value. Admins cannot impersonate
+// themselves, users who may not sign in, or users holding any permission.
+func (is *ImpersonationService) Start(ctx context.Context, admin, target *repository.User, ipAddress net.IP, userAgent string) (_ *repository.Impersonation, err error) {
+	ctx, span := startSpan(ctx, "ImpersonationService.Start")
+	defer func() { endSpan(span, err) }()
+
+	switch {
+	case admin.ID == target.ID:
+		return nil, fmt.Errorf("%w: it is your own account", ErrCannotImpersonate)
+	case !target.Active():
+		return nil, fmt.Errorf("%w: the account is %s", ErrCannotImpersonate, target.Status)
+	}
+	perms, err := is.roles.UserPermissions(ctx, target.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(perms) > 0 {
+		return nil, fmt.Errorf("%w: staff accounts cannot be impersonated", ErrCannotImpersonate)
+	}
+
+	cookieHash, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	imp := &repository.Impersonation{
+		AdminID:      admin.ID,
+		TargetUserID: target.ID,
+		CookieHash:   cookieHash,
+		ExpiresAt:    time.Now().Add(ImpersonationDuration),
+		IPAddress:    ipAddress,
+		UserAgent:    userAgent,
+	}
+	err = is.tx.WithTx(ctx, func(ctx context.Context, tx Stores) error {
+		if err := tx.Impersonations.CreateImpersonation(ctx, imp); err != nil {
+			return err
+		}
+		return is.audit.record(asActor(ctx, admin), tx.Audit, EventImpersonationStarted, target, map[string]string{
+			"expires_at": imp.ExpiresAt.UTC().Format(time.RFC3339),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imp, nil
+}
+
+// Resolve returns the impersonation behind cookieHash if it belongs to
+// admin and has not expired. An expired impersonation is stopped.
+func (is *ImpersonationService) Resolve(ctx context.Context, cookieHash string, admin *repository.User) (_ *repository.Impersonation, err error) {
+	ctx, span := startSpan(ctx, "ImpersonationService.Resolve")
+	defer func() { endSpan(span, err) }()
+
+	imp, err := is.repo.GetImpersonation(ctx, cookieHash)
+	if err != nil {
+		return nil, err
+	}
+	if imp == nil || imp.AdminID != admin.ID {
+		return nil, ErrNotImpersonating
+	}
+	if time.Now().After(imp.ExpiresAt) {
+		if err := is.stop(ctx, imp, "expired"); err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionExpired
+	}
+	return imp, nil
+}
+
+// Stop ends the impersonation admin started behind cookieHash and returns
+// it, or fails with ErrNotImpersonating if there is none.
+func (is *ImpersonationService) Stop(ctx context.Context, cookieHash string, admin *repository.User) (_ *repository.Impersonation, err error) {
+	ctx, span := startSpan(ctx, "ImpersonationService.Stop")
+	defer func() { endSpan(span, err) }()
+
+	imp, err := is.repo.GetImpersonation(ctx, cookieHash)
+	if err != nil {
+		return nil, err
+	}
+	if imp == nil || imp.AdminID != admin.ID {
+		return nil, ErrNotImpersonating
+	}
+	return imp, is.stop(ctx, imp, "stopped")
+}
+
+// stop deletes imp and records why it ended, on behalf of its admin.
+func (is *ImpersonationService) stop(ctx context.Context, imp *repository.Impersonation, reason string) error {
+	info := RequestInfoFrom(ctx)
+	info.ActorID, info.Impersonating = imp.AdminID, ""
+	return is.tx.WithTx(WithRequestInfo(ctx, info), func(ctx context.Context, tx Stores) error {
+		if err := tx.Impersonations.DeleteImpersonation(ctx, imp.CookieHash); err != nil {
+			return err
+		}
+		return is.audit.record(ctx, tx.Audit, EventImpersonationStopped, &repository.User{ID: imp.TargetUserID}, map[string]string{"reason": reason})
+	})
+}
+
+// PurgeExpired deletes every expired impersonation and reports how many
+// were removed.
+func (is *ImpersonationService) PurgeExpired(ctx context.Context) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "ImpersonationService.PurgeExpired")
+	defer func() { endSpan(span, err) }()
+
+	return is.repo.DeleteExpiredImpersonations(ctx)
+}
@@ -0,0 +1,85 @@
+package services_test
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+
+	"template/internal/repository"
+	"template/internal/repository/memory"
+	"template/internal/services"
+)
+
+func TestImpersonation(t *testing.T) {
+	store := memory.NewStore()
+	audit := services.NewAuditService(store.Audit(), slog.New(slog.DiscardHandler))
+	is := services.NewImpersonationService(store.Impersonations(), store.Roles(), store, audit)
+	ctx := t.Context()
+
+	create := func(email string) *repository.User {
+		t.Helper()
+		u, err := store.Users().CreateUser(ctx, &repository.User{Email: email})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	admin, ada, grace := create("admin@example.com"), create("ada@example.com"), create("grace@example.com")
+	if err := store.Roles().AssignRole(ctx, admin.ID, repository.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := is.Start(ctx, admin, admin, nil, ""); !errors.Is(err, services.ErrCannotImpersonate) {
+		t.Errorf("self: err = %v, want ErrCannotImpersonate", err)
+	}
+	if _, err := is.Start(ctx, grace, admin, nil, ""); !errors.Is(err, services.ErrCannotImpersonate) {
+		t.Errorf("staff: err = %v, want ErrCannotImpersonate", err)
+	}
+
+	imp, err := is.Start(ctx, admin, ada, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(imp.ExpiresAt); d <= 0 || d > services.ImpersonationDuration {
+		t.Errorf("expires in %v, want within %v", d, services.ImpersonationDuration)
+	}
+	if _, err := is.Resolve(ctx, imp.CookieHash, grace); !errors.Is(err, services.ErrNotImpersonating) {
+		t.Errorf("Resolve by another user: err = %v, want ErrNotImpersonating", err)
+	}
+	if got, err := is.Resolve(ctx, imp.CookieHash, admin); err != nil || got.TargetUserID != ada.ID {
+		t.Errorf("Resolve = %+v, %v", got, err)
+	}
+
+	// An expired impersonation is stopped when next used.
+	expired := &repository.Impersonation{AdminID: admin.ID, TargetUserID: grace.ID, CookieHash: "expired", ExpiresAt: time.Now().Add(-time.Second)}
+	if err := store.Impersonations().CreateImpersonation(ctx, expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.Resolve(ctx, "expired", admin); !errors.Is(err, services.ErrSessionExpired) {
+		t.Errorf("Resolve expired: err = %v, want ErrSessionExpired", err)
+	}
+	if _, err := is.Stop(ctx, "expired", admin); !errors.Is(err, services.ErrNotImpersonating) {
+		t.Errorf("Stop expired: err = %v, want ErrNotImpersonating", err)
+	}
+
+	if _, err := is.Stop(ctx, imp.CookieHash, grace); !errors.Is(err, services.ErrNotImpersonating) {
+		t.Errorf("Stop by another user: err = %v, want ErrNotImpersonating", err)
+	}
+	if _, err := is.Stop(ctx, imp.CookieHash, admin); err != nil {
+		t.Fatal(err)
+	}
+	events, err := audit.List(ctx, repository.AuditFilter{ActorID: admin.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Type+" "+e.Metadata["reason"])
+	}
+	want := []string{"impersonation.stopped stopped", "impersonation.stopped expired", "impersonation.started "}
+	if !slices.Equal(got, want) {
+		t.Errorf("audit trail = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+	_ "time/tzdata"
+	"unicode"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/text/language"
+
+	"template/internal/repository"
+)
+
+var (
+	ErrInvalidDisplayName = errors.New("display name must be at most 100 characters, without control characters")
+	ErrInvalidLocale      = errors.New("unknown language")
+	ErrInvalidTimezone    = errors.New("unknown time zone")
+	ErrInvalidImage       = errors.New("avatar must be a PNG, JPEG or GIF image")
+	ErrImageTooLarge      = errors.New("avatar must be at most 16 megapixels")
+	ErrAvatarNotFound     = errors.New("avatar not found")
+
+	errNoAvatarStore = errors.New("services: no store for avatars")
+)
+
+const (
+	// MaxDisplayNameLength is the longest display name, in characters.
+	MaxDisplayNameLength = 100
+	// AvatarSize is the side of the square avatars are stored as.
+	AvatarSize = 256
+	// maxAvatarPixels bounds the images SetAvatar decodes, whatever their
+	// size on the wire.
+	maxAvatarPixels = 16 << 20
+)
+
+// Profile is what users may change about how they appear.
+type Profile struct {
+	DisplayName string
+	// Locale is a BCP 47 language tag such as "fr-CA".
+	Locale string
+	// Timezone is an IANA time zone name such as "Europe/Paris".
+	Timezone string
+}
+
+// UpdateProfile validates p and saves it on the user. Empty fields are
+// cleared; the locale is stored in its canonical form.
+func (us *UserService) UpdateProfile(ctx context.Context, user *repository.User, p Profile) (err error) {
+	ctx, span := startSpan(ctx, "UserService.UpdateProfile")
+	defer func() { endSpan(span, err) }()
+
+	if p.DisplayName, err = cleanDisplayName(p.DisplayName); err != nil {
+		return err
+	}
+	if p.Locale, err = canonicalLocale(p.Locale); err != nil {
+		return err
+	}
+	if p.Timezone = strings.TrimSpace(p.Timezone); p.Timezone != "" && !validTimezone(p.Timezone) {
+		return ErrInvalidTimezone
+	}
+
+	return us.update(ctx, user, EventProfileUpdated, func(u *repository.User) (map[string]string, error) {
+		var changed []string
+		for _, f := range []struct {
+			name     string
+			old      *string
+			newValue string
+		}{
+			{"display_name", &u.DisplayName, p.DisplayName},
+			{"locale", &u.Locale, p.Locale},
+			{"timezone", &u.Timezone, p.Timezone},
+		} {
+			if *f.old != f.newValue {
+				*f.old = f.newValue
+				changed = append(changed, f.name)
+			}
+		}
+		if len(changed) == 0 {
+			return nil, errNoChange
+		}
+		return map[string]string{"fields": strings.Join(changed, ",")}, nil
+	})
+}
+
+func cleanDisplayName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > MaxDisplayNameLength || strings.ContainsFunc(name, unicode.IsControl) {
+		return "", ErrInvalidDisplayName
+	}
+	return name, nil
+}
+
+func canonicalLocale(locale string) (string, error) {
+	if locale = strings.TrimSpace(locale); locale == "" {
+		return "", nil
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", ErrInvalidLocale
+	}
+	return tag.String(), nil
+}
+
+// validTimezone reports whether name is an IANA time zone. "Local" is the
+// server's, not a user's.
+func validTimezone(name string) bool {
+	if name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// profileFromGoogle is the profile of a new account, taken from the claims
+// of its Google identity. Claims that do not validate are dropped rather
+// than failing the sign-in.
+func profileFromGoogle(u *repository.User, info *repository.GoogleUser) {
+	if name, err := cleanDisplayName(info.Name); err == nil {
+		u.DisplayName = name
+	}
+	if locale, err := canonicalLocale(info.Locale); err == nil {
+		u.Locale = locale
+	}
+	if strings.HasPrefix(info.Picture, "https://") {
+		u.AvatarURL = info.Picture
+	}
+}
+
+// SetAvatar stores the image read from r as the user's avatar, cropped to a
+// square and scaled down to AvatarSize, and replaces their previous one.
+func (us *UserService) SetAvatar(ctx context.Context, user *repository.User, r io.Reader) (err error) {
+	ctx, span := startSpan(ctx, "UserService.SetAvatar")
+	defer func() { endSpan(span, err) }()
+
+	if us.avatars == nil {
+		return errNoAvatarStore
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	// Check the dimensions before decoding, which allocates for every pixel.
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "png" && format != "jpeg" && format != "gif") {
+		return ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return ErrInvalidImage
+	}
+	if cfg.Width*cfg.Height > maxAvatarPixels {
+		return ErrImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return ErrInvalidImage
+	}
+
+	// Re-encoding also drops whatever metadata the upload carried.
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, squareThumbnail(img, AvatarSize)); err != nil {
+		return err
+	}
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+	key := strings.TrimRight(token, "=") + ".png"
+	if err := us.avatars.Put(ctx, key, &buf); err != nil {
+		return err
+	}
+
+	var old string
+	err = us.update(ctx, user, EventAvatarChanged, func(u *repository.User) (map[string]string, error) {
+		old, u.AvatarKey = u.AvatarKey, key
+		return nil, nil
+	})
+	if err != nil {
+		_ = us.avatars.Delete(ctx, key)
+		return err
+	}
+	us.deleteAvatar(ctx, old)
+	return nil
+}
+
+// RemoveAvatar removes the user's avatar, whether uploaded or taken from
+// their identity provider.
+func (us *UserService) RemoveAvatar(ctx context.Context, user *repository.User) (err error) {
+	ctx, span := startSpan(ctx, "UserService.RemoveAvatar")
+	defer func() { endSpan(span, err) }()
+
+	if user.AvatarKey == "" && user.AvatarURL == "" {
+		return nil
+	}
+	var old string
+	err = us.update(ctx, user, EventAvatarRemoved, func(u *repository.User) (map[string]string, error) {
+		if u.AvatarKey == "" && u.AvatarURL == "" {
+			return nil, errNoChange
+		}
+		old, u.AvatarKey, u.AvatarURL = u.AvatarKey, "", ""
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	us.deleteAvatar(ctx, old)
+	return nil
+}
+
+// OpenAvatar returns the stored avatar under key, a PNG image. An unknown
+// key fails with ErrAvatarNotFound.
+func (us *UserService) OpenAvatar(ctx context.Context, key string) (_ io.ReadCloser, err error) {
+	ctx, span := startSpan(ctx, "UserService.OpenAvatar")
+	defer func() { endSpan(span, err) }()
+
+	if us.avatars == nil || !strings.HasSuffix(key, ".png") {
+		return nil, ErrAvatarNotFound
+	}
+	f, err := us.avatars.Open(ctx, key)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+		return nil, ErrAvatarNotFound
+	}
+	return f, err
+}
+
+// deleteAvatar removes a stored avatar that is no longer referenced. A
+// failure only leaves an unreachable file behind, so it is recorded on the
+// caller's span rather than failing the change that replaced it.
+func (us *UserService) deleteAvatar(ctx context.Context, key string) {
+	if key == "" || us.avatars == nil {
+		return
+	}
+	if err := us.avatars.Delete(ctx, key); err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+	}
+}
+
+// squareThumbnail crops the centre square of src and scales it down to
+// size, averaging the source pixels that fall in each destination pixel.
+// Images smaller than size are only cropped.
+func squareThumbnail(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(crop, crop.Bounds(), src, image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2), draw.Src)
+	if side <= size {
+		return crop
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for dy := range size {
+		y0, y1 := dy*side/size, (dy+1)*side/size
+		for dx := range size {
+			x0, x1 := dx*side/size, (dx+1)*side/size
+			var sum [4]uint32
+			for y := y0; y < y1; y++ {
+				row := crop.Pix[y*crop.Stride+x0*4 : y*crop.Stride+x1*4]
+				for i, v := range row {
+					sum[i%4] += uint32(v)
+				}
+			}
+			n := uint32((y1 - y0) * (x1 - x0))
+			o := dst.PixOffset(dx, dy)
+			for i := range sum {
+				dst.Pix[o+i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
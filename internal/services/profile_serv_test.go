@@ -0,0 +1,154 @@
+package services_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"template/internal/repository"
+	"template/internal/repository/memory"
+	"template/internal/services"
+	"template/internal/storage"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	us := newUserService()
+	ctx := t.Context()
+	u, err := us.Create(ctx, repository.User{Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		p    services.Profile
+		want error
+	}{
+		{services.Profile{DisplayName: strings.Repeat("é", services.MaxDisplayNameLength+1)}, services.ErrInvalidDisplayName},
+		{services.Profile{DisplayName: "Ada\nLovelace"}, services.ErrInvalidDisplayName},
+		{services.Profile{Locale: "not a language"}, services.ErrInvalidLocale},
+		{services.Profile{Timezone: "Mars/Olympus_Mons"}, services.ErrInvalidTimezone},
+		{services.Profile{Timezone: "Local"}, services.ErrInvalidTimezone},
+	} {
+		if err := us.UpdateProfile(ctx, u, tc.p); !errors.Is(err, tc.want) {
+			t.Errorf("UpdateProfile(%+v) error = %v, want %v", tc.p, err, tc.want)
+		}
+	}
+
+	if err := us.UpdateProfile(ctx, u, services.Profile{DisplayName: " Ada ", Locale: "EN-gb", Timezone: "Europe/London"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := us.Get(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DisplayName != "Ada" || got.Locale != "en-GB" || got.Timezone != "Europe/London" {
+		t.Errorf("profile = %q, %q, %q", got.DisplayName, got.Locale, got.Timezone)
+	}
+}
+
+func TestGoogleProfile(t *testing.T) {
+	us := newUserService()
+	u, err := us.RegisterGoogleUser(t.Context(), &repository.GoogleUser{
+		Id:      "g-1",
+		Email:   "ada@example.com",
+		Name:    "Ada Lovelace",
+		Picture: "https://lh3.googleusercontent.com/a/ada",
+		Locale:  "fr",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.DisplayName != "Ada Lovelace" || u.AvatarURL != "https://lh3.googleusercontent.com/a/ada" || u.Locale != "fr" {
+		t.Errorf("profile from claims = %+v", u)
+	}
+}
+
+func pngImage(t *testing.T, w, h int) *bytes.Buffer {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestAvatar(t *testing.T) {
+	dir := t.TempDir()
+	store := memory.NewStore()
+	us := services.NewUserService(store.Users(), store, storage.NewDisk(dir), nil)
+	ctx := t.Context()
+	u, err := us.Create(ctx, repository.User{Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := us.SetAvatar(ctx, u, strings.NewReader("not an image")); !errors.Is(err, services.ErrInvalidImage) {
+		t.Errorf("SetAvatar(garbage) error = %v, want ErrInvalidImage", err)
+	}
+	// A GIF header claiming 65535x65535 pixels is refused before decoding.
+	if err := us.SetAvatar(ctx, u, strings.NewReader("GIF89a\xff\xff\xff\xff\x00\x00\x00;")); !errors.Is(err, services.ErrImageTooLarge) {
+		t.Errorf("SetAvatar(huge) error = %v, want ErrImageTooLarge", err)
+	}
+
+	if err := us.SetAvatar(ctx, u, pngImage(t, 600, 300)); err != nil {
+		t.Fatal(err)
+	}
+	first := u.AvatarKey
+	f, err := us.OpenAvatar(ctx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, format, err := image.Decode(f)
+	f.Close()
+	if err != nil || format != "png" {
+		t.Fatalf("stored avatar: %v, %q", err, format)
+	}
+	if b := img.Bounds(); b.Dx() != services.AvatarSize || b.Dy() != services.AvatarSize {
+		t.Errorf("avatar is %dx%d, want %[3]dx%[3]d", b.Dx(), b.Dy(), services.AvatarSize)
+	}
+
+	// Small images are cropped but not scaled up.
+	if err := us.SetAvatar(ctx, u, pngImage(t, 40, 64)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, first)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("replaced avatar kept: %v", err)
+	}
+	f, err = us.OpenAvatar(ctx, u.AvatarKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil || cfg.Width != 40 || cfg.Height != 40 {
+		t.Errorf("small avatar = %dx%d, %v; want 40x40", cfg.Width, cfg.Height, err)
+	}
+
+	for _, key := range []string{"missing.png", "../" + u.AvatarKey, u.AvatarKey + "x"} {
+		if _, err := us.OpenAvatar(ctx, key); !errors.Is(err, services.ErrAvatarNotFound) {
+			t.Errorf("OpenAvatar(%q) error = %v, want ErrAvatarNotFound", key, err)
+		}
+	}
+
+	// Deleting the user deletes their avatar.
+	second := u.AvatarKey
+	if err := us.Delete(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, second)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("avatar kept after deleting the user: %v", err)
+	}
+}
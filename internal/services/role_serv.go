@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"template/internal/repository"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+// Permissions is the set of permissions a user holds through their roles.
+type Permissions map[string]struct{}
+
+// Has reports whether perm is in the set.
+func (p Permissions) Has(perm string) bool {
+	_, ok := p[perm]
+	return ok
+}
+
+type RoleService struct {
+	repo  RoleStore
+	tx    Transactor
+	audit *AuditService
+}
+
+// NewRoleService returns a RoleService recording role changes to audit,
+// which may be nil, in the unit of work of tx that makes them.
+func NewRoleService(repo RoleStore, tx Transactor, audit *AuditService) *RoleService {
+	return &RoleService{repo: repo, tx: tx, audit: audit}
+}
+
+// Roles returns every role with its permissions.
+func (rs *RoleService) Roles(ctx context.Context) (_ []repository.Role, err error) {
+	ctx, span := startSpan(ctx, "RoleService.Roles")
+	defer func() { endSpan(span, err) }()
+
+	return rs.repo.ListRoles(ctx)
+}
+
+// UserRoles returns the names of the user's roles.
+func (rs *RoleService) UserRoles(ctx context.Context, user *repository.User) (_ []string, err error) {
+	ctx, span := startSpan(ctx, "RoleService.UserRoles")
+	defer func() { endSpan(span, err) }()
+
+	return rs.repo.UserRoles(ctx, user.ID)
+}
+
+// Permissions returns what the user may do.
+func (rs *RoleService) Permissions(ctx context.Context, user *repository.User) (_ Permissions, err error) {
+	ctx, span := startSpan(ctx, "RoleService.Permissions")
+	defer func() { endSpan(span, err) }()
+
+	names, err := rs.repo.UserPermissions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	perms := make(Permissions, len(names))
+	for _, name := range names {
+		perms[name] = struct{}{}
+	}
+	return perms, nil
+}
+
+// Assign gives the user a role, failing with ErrUnknownRole if there is no
+// role by that name.
+func (rs *RoleService) Assign(ctx context.Context, user *repository.User, role string) (err error) {
+	ctx, span := startSpan(ctx, "RoleService.Assign")
+	defer func() { endSpan(span, err) }()
+
+	err = rs.tx.WithTx(ctx, func(ctx context.Context, tx Stores) error {
+		if err := tx.Roles.AssignRole(ctx, user.ID, role); err != nil {
+			return err
+		}
+		return rs.audit.record(ctx, tx.Audit, EventRoleAssigned, user, map[string]string{"role": role})
+	})
+	if errors.Is(err, repository.ErrForeignKeyViolation) {
+		return fmt.Errorf("%w %q", ErrUnknownRole, role)
+	}
+	return err
+}
+
+// Unassign takes a role away from the user.
+func (rs *RoleService) Unassign(ctx context.Context, user *repository.User, role string) (err error) {
+	ctx, span := startSpan(ctx, "RoleService.Unassign")
+	defer func() { endSpan(span, err) }()
+
+	return rs.tx.WithTx(ctx, func(ctx context.Context, tx Stores) error {
+		if err := tx.Roles.UnassignRole(ctx, user.ID, role); err != nil {
+			return err
+		}
+		return rs.audit.record(ctx, tx.Audit, EventRoleUnassigned, user, map[string]string{"role": role})
+	})
+}
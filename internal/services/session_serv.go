@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
 	"errors"
 	"net"
@@ -17,15 +18,22 @@ var (
 )
 
 type SessionService struct {
-	repo *repository.SessionRepository
+	repo  SessionStore
+	tx    Transactor
+	audit *AuditService
 	// Session configuration
 	sessionDuration time.Duration
 	maxSessions     int
 }
 
-func NewSessionService(repo *repository.SessionRepository) *SessionService {
+// NewSessionService returns a SessionService recording sign-ins, sign-outs
+// and revocations to audit, which may be nil, in the unit of work of tx
+// that makes them.
+func NewSessionService(repo SessionStore, tx Transactor, audit *AuditService) *SessionService {
 	return &SessionService{
 		repo:            repo,
+		tx:              tx,
+		audit:           audit,
 		sessionDuration: 24 * time.Hour, // Default session duration
 		maxSessions:     5,              // Maximum concurrent sessions per user
 	}
@@ -33,6 +41,11 @@ func NewSessionService(repo *repository.SessionRepository) *SessionService {
 
 // GenerateSessionToken generates a cryptographically secure random token
 func (s *SessionService) GenerateSessionToken() (string, error) {
+	return generateToken()
+}
+
+// generateToken returns a random cookie value.
+func generateToken() (string, error) {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
 		return "", err
@@ -41,36 +54,62 @@ func (s *SessionService) GenerateSessionToken() (string, error) {
 }
 
 // CreateSession creates a new session for a user with security best practices
-func (s *SessionService) CreateSession(ctx context.Context, userID string, ipAddress net.IP, userAgent string) (string, error) {
+func (s *SessionService) CreateSession(ctx context.Context, userID string, ipAddress net.IP, userAgent string) (_ string, err error) {
+	ctx, span := startSpan(ctx, "SessionService.CreateSession")
+	defer func() { endSpan(span, err) }()
+
+	return s.createSession(ctx, s.repo, userID, ipAddress, userAgent)
+}
+
+// SignIn creates a session for a user who has just authenticated with
+// method, such as "password", and records the sign-in with it. It returns
+// the session cookie value.
+func (s *SessionService) SignIn(ctx context.Context, user *repository.User, method string, ipAddress net.IP, userAgent string) (_ string, err error) {
+	ctx, span := startSpan(ctx, "SessionService.SignIn")
+	defer func() { endSpan(span, err) }()
+
+	var cookie string
+	err = s.tx.WithTx(ctx, func(ctx context.Context, tx Stores) error {
+		var err error
+		if cookie, err = s.createSession(ctx, tx.Sessions, user.ID, ipAddress, userAgent); err != nil {
+			return err
+		}
+		return s.audit.record(asActor(ctx, user), tx.Audit, EventLoginSucceeded, user, map[string]string{"method": method})
+	})
+	if err != nil {
+		return "", err
+	}
+	return cookie, nil
+}
+
+// createSession stores a new session through repo, which may be bound to a
+// transaction.
+func (s *SessionService) createSession(ctx context.Context, repo SessionStore, userID string, ipAddress net.IP, userAgent string) (string, error) {
 	// Generate secure session token
 	cookieHash, err := s.GenerateSessionToken()
 	if err != nil {
 		return "", err
 	}
 
+	// Set expiration time
+	expiresAt := time.Now().Add(s.sessionDuration)
 	session := repository.Session{
 		UserID:     userID,
 		CookieHash: cookieHash,
 		IPAddress:  ipAddress,
 		UserAgent:  userAgent,
-		ExpiresAt:  &time.Time{},
+		ExpiresAt:  &expiresAt,
 	}
 
-	// Set expiration time
-	expiresAt := time.Now().Add(s.sessionDuration)
-	session.ExpiresAt = &expiresAt
-
 	// Create the session
-	cookieHash, err = s.repo.Create(ctx, session)
-	if err != nil {
-		return "", err
-	}
-
-	return cookieHash, nil
+	return repo.Create(ctx, session)
 }
 
 // ValidateSession validates a session and checks for expiration
-func (s *SessionService) ValidateSession(ctx context.Context, cookieHash string) (repository.Session, error) {
+func (s *SessionService) ValidateSession(ctx context.Context, cookieHash string) (_ repository.Session, err error) {
+	ctx, span := startSpan(ctx, "SessionService.ValidateSession")
+	defer func() { endSpan(span, err) }()
+
 	session, err := s.repo.GetByCookieHash(ctx, cookieHash)
 	if err != nil {
 		return repository.Session{}, ErrInvalidSession
@@ -87,9 +126,12 @@ func (s *SessionService) ValidateSession(ctx context.Context, cookieHash string)
 }
 
 // RefreshSession extends the session duration
-func (s *SessionService) RefreshSession(ctx context.Context, cookieHash string) error {
+func (s *SessionService) RefreshSession(ctx context.Context, cookieHash string) (err error) {
+	ctx, span := startSpan(ctx, "SessionService.RefreshSession")
+	defer func() { endSpan(span, err) }()
+
 	// Validate session first
-	_, err := s.ValidateSession(ctx, cookieHash)
+	_, err = s.ValidateSession(ctx, cookieHash)
 	if err != nil {
 		return err
 	}
@@ -100,11 +142,60 @@ func (s *SessionService) RefreshSession(ctx context.Context, cookieHash string)
 }
 
 // RevokeSession invalidates a session
-func (s *SessionService) RevokeSession(ctx context.Context, cookieHash string) error {
+func (s *SessionService) RevokeSession(ctx context.Context, cookieHash string) (err error) {
+	ctx, span := startSpan(ctx, "SessionService.RevokeSession")
+	defer func() { endSpan(span, err) }()
+
 	return s.repo.DeleteByCookieHash(ctx, cookieHash)
 }
 
+// Logout ends the session a user signs out of. An unknown session is not an
+// error: the user is signed out either way.
+func (s *SessionService) Logout(ctx context.Context, cookieHash string) (err error) {
+	ctx, span := startSpan(ctx, "SessionService.Logout")
+	defer func() { endSpan(span, err) }()
+
+	return s.tx.WithTx(ctx, func(ctx context.Context, tx Stores) error {
+		session, err := tx.Sessions.GetByCookieHash(ctx, cookieHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Sessions.DeleteByCookieHash(ctx, cookieHash); err != nil {
+			return err
+		}
+		user := &repository.User{ID: session.UserID}
+		return s.audit.record(asActor(ctx, user), tx.Audit, EventLogout, user, nil)
+	})
+}
+
+// ListUserSessions returns the user's sessions, newest first.
+func (s *SessionService) ListUserSessions(ctx context.Context, userID string) (_ []repository.Session, err error) {
+	ctx, span := startSpan(ctx, "SessionService.ListUserSessions")
+	defer func() { endSpan(span, err) }()
+
+	return s.repo.ListByUserID(ctx, userID)
+}
+
 // RevokeAllUserSessions invalidates all sessions for a given user
-func (s *SessionService) RevokeAllUserSessions(ctx context.Context, userID string) error {
-	return s.repo.DeleteByUserID(ctx, userID)
+func (s *SessionService) RevokeAllUserSessions(ctx context.Context, userID string) (err error) {
+	ctx, span := startSpan(ctx, "SessionService.RevokeAllUserSessions")
+	defer func() { endSpan(span, err) }()
+
+	return s.tx.WithTx(ctx, func(ctx context.Context, tx Stores) error {
+		if err := tx.Sessions.DeleteByUserID(ctx, userID); err != nil {
+			return err
+		}
+		return s.audit.record(ctx, tx.Audit, EventSessionsRevoked, &repository.User{ID: userID}, nil)
+	})
+}
+
+// PurgeExpired deletes every expired session and reports how many were removed.
+func (s *SessionService) PurgeExpired(ctx context.Context) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "SessionService.PurgeExpired")
+	defer func() { endSpan(span, err) }()
+
+	return s.repo.DeleteExpired(ctx)
 }
@@ -0,0 +1,65 @@
+package services_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"template/internal/repository"
+	"template/internal/repository/memory"
+	"template/internal/services"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+	store := memory.NewStore()
+	ss := services.NewSessionService(store.Sessions(), store, nil)
+	ctx := t.Context()
+
+	u, err := store.Users().CreateUser(ctx, &repository.User{Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := ss.CreateSession(ctx, u.ID, net.ParseIP("192.0.2.1"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ss.ValidateSession(ctx, cookie)
+	if err != nil || s.UserID != u.ID {
+		t.Fatalf("ValidateSession = %+v, %v", s, err)
+	}
+
+	if err := ss.RevokeSession(ctx, cookie); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.ValidateSession(ctx, cookie); !errors.Is(err, services.ErrInvalidSession) {
+		t.Errorf("ValidateSession after revoke error = %v, want ErrInvalidSession", err)
+	}
+}
+
+func TestExpiredSession(t *testing.T) {
+	store := memory.NewStore()
+	ss := services.NewSessionService(store.Sessions(), store, nil)
+	ctx := t.Context()
+
+	u, err := store.Users().CreateUser(ctx, &repository.User{Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := ss.CreateSession(ctx, u.ID, nil, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Sessions().UpdateExpiry(ctx, cookie, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ss.ValidateSession(ctx, cookie); !errors.Is(err, services.ErrSessionExpired) {
+		t.Errorf("ValidateSession error = %v, want ErrSessionExpired", err)
+	}
+	// The expired session was removed while validating it.
+	if n, err := ss.PurgeExpired(ctx); err != nil || n != 0 {
+		t.Errorf("PurgeExpired = %d, %v; want 0", n, err)
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"time"
+
+	"template/internal/repository"
+)
+
+// UserStore is the user storage UserService needs. repository.UserRepo
+// implements it over Postgres and memory.UserRepo in memory.
+//
+// Lookups return a nil user and no error when nothing matches; writes that
+// would duplicate an email or Google ID fail with
+// repository.ErrUniqueViolation.
+type UserStore interface {
+	CreateUser(ctx context.Context, u *repository.User) (*repository.User, error)
+	GetUserByID(ctx context.Context, id string) (*repository.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*repository.User, error)
+	GetUserByGoogleID(ctx context.Context, gid string) (*repository.User, error)
+	ListUsers(ctx context.Context, opts repository.ListUsersOptions) (*repository.UserPage, error)
+	UpdateUser(ctx context.Context, u *repository.User) error
+	DeleteUser(ctx context.Context, id string) error
+}
+
+// SessionStore is the session storage SessionService needs.
+// repository.SessionRepository implements it over Postgres and
+// memory.SessionRepository in memory.
+//
+// A user holds at most one session: Create replaces any previous one.
+// GetByCookieHash fails with sql.ErrNoRows for an unknown cookie.
+type SessionStore interface {
+	Create(ctx context.Context, s repository.Session) (string, error)
+	GetByCookieHash(ctx context.Context, cookieHash string) (repository.Session, error)
+	DeleteByCookieHash(ctx context.Context, cookieHash string) error
+	UpdateExpiry(ctx context.Context, cookieHash string, expiresAt time.Time) error
+	ListByUserID(ctx context.Context, userID string) ([]repository.Session, error)
+	DeleteByUserID(ctx context.Context, userID string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// ImpersonationStore is the impersonation storage ImpersonationService
+// needs. repository.ImpersonationRepository implements it over Postgres and
+// memory.ImpersonationRepository in memory.
+//
+// GetImpersonation returns nil and no error for an unknown cookie.
+// Impersonations go when either user is deleted.
+type ImpersonationStore interface {
+	CreateImpersonation(ctx context.Context, imp *repository.Impersonation) error
+	GetImpersonation(ctx context.Context, cookieHash string) (*repository.Impersonation, error)
+	DeleteImpersonation(ctx context.Context, cookieHash string) error
+	DeleteExpiredImpersonations(ctx context.Context) (int64, error)
+}
+
+// RoleStore is the role storage RoleService needs. repository.RoleRepository
+// implements it over Postgres and memory.RoleRepository in memory.
+//
+// The admin role and the permissions named by the repository Perm constants
+// always exist. Assigning an unknown role, or a role to an unknown user,
+// fails with repository.ErrForeignKeyViolation; a user's roles go when the
+// user is deleted.
+type RoleStore interface {
+	ListRoles(ctx context.Context) ([]repository.Role, error)
+	UserRoles(ctx context.Context, userID string) ([]string, error)
+	UserPermissions(ctx context.Context, userID string) ([]string, error)
+	AssignRole(ctx context.Context, userID, role string) error
+	UnassignRole(ctx context.Context, userID, role string) error
+}
+
+// AuditStore is the audit trail storage AuditService needs.
+// repository.AuditRepository implements it over Postgres and
+// memory.AuditRepository in memory. Events are only ever appended, and
+// leave the trail only when they outlive the retention period.
+type AuditStore interface {
+	RecordAuditEvent(ctx context.Context, e *repository.AuditEvent) error
+	ListAuditEvents(ctx context.Context, f repository.AuditFilter) ([]repository.AuditEvent, error)
+	DeleteAuditEventsBefore(ctx context.Context, t time.Time) (int64, error)
+}
+
+// BlobStore holds the files users upload, such as avatars, by key.
+// storage.Disk implements it on the local disk.
+//
+// Put replaces any file under the same key. Open fails with an error
+// matching fs.ErrNotExist for an unknown key; deleting one is not an error.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Stores are the stores a unit of work runs against.
+type Stores struct {
+	Users          UserStore
+	Sessions       SessionStore
+	Impersonations ImpersonationStore
+	Roles          RoleStore
+	Audit          AuditStore
+}
+
+// Transactor runs units of work atomically: fn sees stores bound to one
+// transaction, whose writes are all kept if fn returns nil and all
+// discarded otherwise. fn may be retried and must only write through tx.
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context, tx Stores) error) error
+}
+
+// SQLTransactor is the Transactor over the Postgres repositories.
+type SQLTransactor struct {
+	DB     *sql.DB
+	Logger *slog.Logger
+}
+
+func (t SQLTransactor) WithTx(ctx context.Context, fn func(ctx context.Context, tx Stores) error) error {
+	return repository.WithTx(ctx, t.DB, func(tx *sql.Tx) error {
+		return fn(ctx, Stores{
+			Users:          repository.NewUserRepo(tx, t.Logger),
+			Sessions:       &repository.SessionRepository{DB: tx},
+			Impersonations: &repository.ImpersonationRepository{DB: tx},
+			Roles:          &repository.RoleRepository{DB: tx},
+			Audit:          &repository.AuditRepository{DB: tx},
+		})
+	})
+}
@@ -2,50 +2,543 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
 
 	"template/internal/repository"
 	"template/utils"
 )
 
-var ErrEmailAlreadyExist = errors.New("email already exists")
+var (
+	ErrEmailAlreadyExist  = errors.New("email already exists")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrInvalidPassword    = errors.New("password must be between 8 and 72 characters")
+	ErrAccountInactive    = errors.New("account is not active")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrNotPendingDeletion = errors.New("account is not pending deletion")
+	ErrNoOtherSignIn      = errors.New("account has no other way to sign in")
+)
+
+// InactiveAccountError is returned when a user whose account is suspended,
+// banned or pending deletion tries to sign in. It matches
+// ErrAccountInactive.
+type InactiveAccountError struct {
+	User *repository.User
+}
+
+func (e *InactiveAccountError) Error() string {
+	return "account is " + strings.ReplaceAll(e.User.Status, "_", " ")
+}
+
+func (e *InactiveAccountError) Unwrap() error {
+	return ErrAccountInactive
+}
 
 type UserService struct {
-	UR *repository.UserRepo
+	UR      UserStore
+	tx      Transactor
+	avatars BlobStore
+	audit   *AuditService
 }
 
-func NewUserService(ur *repository.UserRepo) *UserService {
-	return &UserService{UR: ur}
+// NewUserService returns a UserService keeping uploaded avatars in
+// avatars and recording what it does to audit. Either may be nil when the
+// caller does not handle avatars or keep an audit trail.
+func NewUserService(ur UserStore, tx Transactor, avatars BlobStore, audit *AuditService) *UserService {
+	return &UserService{UR: ur, tx: tx, avatars: avatars, audit: audit}
 }
 
-func (us *UserService) Create(ctx context.Context, user repository.User) (*repository.User, error) {
-	exist, _ := us.UR.GetUserByEmail(ctx, user.Email)
-	if exist != nil && exist.ID != "" {
+// Create stores a new user. user.PasswordHash holds the clear password, if
+// any, and is hashed here. A taken email fails with ErrEmailAlreadyExist.
+func (us *UserService) Create(ctx context.Context, user repository.User) (_ *repository.User, err error) {
+	ctx, span := startSpan(ctx, "UserService.Create")
+	defer func() { endSpan(span, err) }()
+
+	if err := hashPassword(&user); err != nil {
+		return nil, err
+	}
+	var created *repository.User
+	err = us.tx.WithTx(ctx, func(ctx context.Context, tx Stores) error {
+		var err error
+		if created, err = createUser(ctx, tx.Users, &user); err != nil {
+			return err
+		}
+		return us.audit.record(ctx, tx.Audit, EventUserCreated, created, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// Register creates a password account and its first session in one unit
+// of work, so a failure leaves neither behind. It returns the session
+// cookie value.
+func (us *UserService) Register(ctx context.Context, user repository.User, ss *SessionService, ipAddress net.IP, userAgent string) (_ *repository.User, _ string, err error) {
+	ctx, span := startSpan(ctx, "UserService.Register")
+	defer func() { endSpan(span, err) }()
+
+	// Hash outside the transaction: it is slow and needn't be retried.
+	if err := hashPassword(&user); err != nil {
+		return nil, "", err
+	}
+
+	var created *repository.User
+	var cookie string
+	err = us.tx.WithTx(ctx, func(ctx context.Context, tx Stores) error {
+		var err error
+		if created, err = createUser(ctx, tx.Users, &user); err != nil {
+			return err
+		}
+		if cookie, err = ss.createSession(ctx, tx.Sessions, created.ID, ipAddress, userAgent); err != nil {
+			return err
+		}
+		return us.audit.record(asActor(ctx, created), tx.Audit, EventUserRegistered, created, map[string]string{"method": "password"})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return created, cookie, nil
+}
+
+// createUser relies on the unique email constraint rather than a prior
+// lookup, which a concurrent signup could slip past.
+func createUser(ctx context.Context, users UserStore, user *repository.User) (*repository.User, error) {
+	created, err := users.CreateUser(ctx, user)
+	if errors.Is(err, repository.ErrUniqueViolation) {
 		return nil, ErrEmailAlreadyExist
 	}
-	// Make PasswordHash nullable
-	hashedPassword := sql.NullString{}
-	if user.PasswordHash.Valid {
-		hash, _ := utils.HashPassword(user.PasswordHash.String)
-		hashedPassword = sql.NullString{String: hash, Valid: true}
+	return created, err
+}
+
+// hashPassword replaces the clear password in user.PasswordHash by its hash.
+func hashPassword(user *repository.User) error {
+	if !user.PasswordHash.Valid {
+		return nil
+	}
+	hash, err := utils.HashPassword(user.PasswordHash.String)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = sql.NullString{String: hash, Valid: true}
+	return nil
+}
+
+// Get returns the user with the given ID, or nil if there is none.
+func (us *UserService) Get(ctx context.Context, id string) (_ *repository.User, err error) {
+	ctx, span := startSpan(ctx, "UserService.Get")
+	defer func() { endSpan(span, err) }()
+
+	return us.UR.GetUserByID(ctx, id)
+}
+
+// GetByEmail returns the user with the given email, or nil if there is none.
+func (us *UserService) GetByEmail(ctx context.Context, email string) (_ *repository.User, err error) {
+	ctx, span := startSpan(ctx, "UserService.GetByEmail")
+	defer func() { endSpan(span, err) }()
+
+	return us.UR.GetUserByEmail(ctx, email)
+}
+
+// Page size bounds for List.
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// List returns one page of users. A zero limit means DefaultPageSize and
+// larger limits are capped at MaxPageSize.
+func (us *UserService) List(ctx context.Context, opts repository.ListUsersOptions) (_ *repository.UserPage, err error) {
+	ctx, span := startSpan(ctx, "UserService.List")
+	defer func() { endSpan(span, err) }()
+
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultPageSize
+	}
+	opts.Limit = min(opts.Limit, MaxPageSize)
+	return us.UR.ListUsers(ctx, opts)
+}
+
+// dummyHash is compared against when there is no password to check.
+var dummyHash = sync.OnceValue(func() string {
+	hash, err := utils.HashPassword("not the password of anyone")
+	if err != nil {
+		panic(err)
 	}
-	user.PasswordHash = hashedPassword
-	return us.UR.CreateUser(ctx, &user)
+	return hash
+})
+
+// maxAttemptedEmail is the longest address recorded verbatim; RFC 5321
+// limits a path to 254 characters.
+const maxAttemptedEmail = 254
+
+// attemptedEmail is what a failed login for an unknown email records. The
+// value is attacker-supplied, so anything that is not a plausible address
+// is replaced by a short digest: it still groups repeated attempts without
+// putting arbitrary text in the trail.
+func attemptedEmail(email string) string {
+	email = utils.CleanString(email)
+	if len(email) <= maxAttemptedEmail {
+		if addr, err := mail.ParseAddress(email); err == nil && addr.Address == email {
+			return email
+		}
+	}
+	sum := sha256.Sum256([]byte(email))
+	return "sha256:" + hex.EncodeToString(sum[:16])
 }
 
-func (us *UserService) RegisterGoogleUser(ctx context.Context, info *repository.GoogleUser) (*repository.User, error) {
-	exist, _ := us.UR.GetUserByGoogleID(ctx, info.Id)
-	if exist != nil && exist.ID != "" {
-		return exist, nil
+// Authenticate checks an email/password pair and returns the matching user.
+// Failures are recorded; the success is, with the session, by
+// SessionService.SignIn.
+func (us *UserService) Authenticate(ctx context.Context, email, password string) (_ *repository.User, err error) {
+	ctx, span := startSpan(ctx, "UserService.Authenticate")
+	defer func() { endSpan(span, err) }()
+
+	user, err := us.UR.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	failed := func(reason string) {
+		metadata := map[string]string{"method": "password", "reason": reason}
+		if user == nil {
+			metadata["email"] = attemptedEmail(email)
+		}
+		us.audit.recordAttempt(ctx, EventLoginFailed, user, metadata)
+	}
+	if user == nil || !user.PasswordHash.Valid {
+		// Spend the time a real comparison takes, so that response times
+		// do not tell which emails have a password account.
+		_, _ = utils.CompareHash(dummyHash(), password)
+		if user == nil {
+			failed("unknown_email")
+		} else {
+			failed("no_password")
+		}
+		return nil, ErrInvalidCredentials
+	}
+	ok, err := utils.CompareHash(user.PasswordHash.String, password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		failed("invalid_password")
+		return nil, ErrInvalidCredentials
+	}
+	if !user.Active() {
+		failed(user.Status)
+		return nil, &InactiveAccountError{User: user}
+	}
+	return user, nil
+}
+
+func (us *UserService) RegisterGoogleUser(ctx context.Context, info *repository.GoogleUser) (_ *repository.User, err error) {
+	ctx, span := startSpan(ctx, "UserService.RegisterGoogleUser")
+	defer func() { endSpan(span, err) }()
+
+	exist, err := us.UR.GetUserByGoogleID(ctx, info.Id)
+	if err != nil || exist != nil {
+		return us.signIn(ctx, exist, err)
 	}
 	u := &repository.User{
 		Email:    info.Email,
 		GoogleID: sql.NullString{String: info.Id, Valid: true},
 	}
-	return us.UR.CreateUser(ctx, u)
+	profileFromGoogle(u, info)
+	var created *repository.User
+	err = us.tx.WithTx(ctx, func(ctx context.Context, tx Stores) error {
+		var err error
+		if created, err = tx.Users.CreateUser(ctx, u); err != nil {
+			return err
+		}
+		ctx = asActor(ctx, created)
+		if err := us.audit.record(ctx, tx.Audit, EventUserRegistered, created, map[string]string{"method": "google"}); err != nil {
+			return err
+		}
+		return us.audit.record(ctx, tx.Audit, EventIdentityLinked, created, map[string]string{"provider": "google"})
+	})
+	if errors.Is(err, repository.ErrUniqueViolation) {
+		// Either a concurrent sign-in created the account first, or the
+		// email belongs to a different account.
+		if exist, err := us.UR.GetUserByGoogleID(ctx, info.Id); err != nil || exist != nil {
+			return us.signIn(ctx, exist, err)
+		}
+		return nil, ErrEmailAlreadyExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// signIn passes a user found by their Google ID through if they may sign
+// in, and records a refusal. Signing in with Google restores an account
+// pending deletion, as the restore form does for a password.
+func (us *UserService) signIn(ctx context.Context, user *repository.User, err error) (*repository.User, error) {
+	if err != nil || user == nil {
+		return user, err
+	}
+	if user.Status == repository.StatusPendingDeletion {
+		if err := us.CancelDeletion(asActor(ctx, user), user); err != nil {
+			return nil, err
+		}
+	}
+	if !user.Active() {
+		us.audit.recordAttempt(ctx, EventLoginFailed, user, map[string]string{"method": "google", "reason": user.Status})
+		return nil, &InactiveAccountError{User: user}
+	}
+	return user, nil
+}
+
+func (us *UserService) Delete(ctx context.Context, user *repository.User) (err error) {
+	ctx, span := startSpan(ctx, "UserService.Delete")
+	defer func() { endSpan(span, err) }()
+
+	err = us.tx.WithTx(ctx, func(ctx context.Context, tx Stores) error {
+		if err := tx.Users.DeleteUser(ctx, user.ID); err != nil {
+			return err
+		}
+		return us.audit.record(ctx, tx.Audit, EventUserDeleted, user, nil)
+	})
+	if err != nil {
+		return err
+	}
+	us.deleteAvatar(ctx, user.AvatarKey)
+	return nil
+}
+
+// SetPassword replaces the user's password hash.
+func (us *UserService) SetPassword(ctx context.Context, user *repository.User, password string) (err error) {
+	ctx, span := startSpan(ctx, "UserService.SetPassword")
+	defer func() { endSpan(span, err) }()
+
+	if !utils.IsValidPasswordLength(password) {
+		return ErrInvalidPassword
+	}
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return us.update(ctx, user, EventPasswordChanged, func(u *repository.User) (map[string]string, error) {
+		u.PasswordHash = sql.NullString{String: hash, Valid: true}
+		return nil, nil
+	})
+}
+
+// ClearPassword removes the user's password, so that they can only sign
+// in through a linked identity. It fails with ErrNoOtherSignIn when there
+// is none, as the account would be locked out.
+func (us *UserService) ClearPassword(ctx context.Context, user *repository.User) (err error) {
+	ctx, span := startSpan(ctx, "UserService.ClearPassword")
+	defer func() { endSpan(span, err) }()
+
+	return us.update(ctx, user, EventPasswordCleared, func(u *repository.User) (map[string]string, error) {
+		if !u.GoogleID.Valid {
+			return nil, ErrNoOtherSignIn
+		}
+		u.PasswordHash = sql.NullString{}
+		return nil, nil
+	})
+}
+
+// Suspend stops the user from signing in until the given time, or until
+// reactivated if it is zero, and ends their sessions. The reason is shown
+// to the user.
+func (us *UserService) Suspend(ctx context.Context, user *repository.User, reason string, until time.Time) (err error) {
+	ctx, span := startSpan(ctx, "UserService.Suspend")
+	defer func() { endSpan(span, err) }()
+
+	expires := sql.NullTime{Time: until, Valid: !until.IsZero()}
+	return us.setStatus(ctx, user, EventUserSuspended, "", repository.StatusSuspended, reason, expires)
+}
+
+// Ban stops the user from signing in until reactivated and ends their
+// sessions. The reason is shown to the user.
+func (us *UserService) Ban(ctx context.Context, user *repository.User, reason string) (err error) {
+	ctx, span := startSpan(ctx, "UserService.Ban")
+	defer func() { endSpan(span, err) }()
+
+	return us.setStatus(ctx, user, EventUserBanned, "", repository.StatusBanned, reason, sql.NullTime{})
+}
+
+// Reactivate lifts a suspension or ban.
+func (us *UserService) Reactivate(ctx context.Context, user *repository.User) (err error) {
+	ctx, span := startSpan(ctx, "UserService.Reactivate")
+	defer func() { endSpan(span, err) }()
+
+	return us.setStatus(ctx, user, EventUserReactivated, "", repository.StatusActive, "", sql.NullTime{})
+}
+
+// setStatus saves the user's new status in one unit of work with the
+// revocation of their sessions, unless the status lets them sign in, and
+// the event recording it. A non-empty from is the status the user must
+// have, or it fails with ErrNotPendingDeletion.
+func (us *UserService) setStatus(ctx context.Context, user *repository.User, event, from, status, reason string, expires sql.NullTime) error {
+	var metadata map[string]string
+	if reason != "" {
+		metadata = map[string]string{"reason": reason}
+	}
+	if expires.Valid {
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata["until"] = expires.Time.UTC().Format(time.RFC3339)
+	}
+
+	return us.modify(ctx, user, func(ctx context.Context, tx Stores, u *repository.User) error {
+		if from != "" && u.Status != from {
+			return ErrNotPendingDeletion
+		}
+		u.Status = status
+		u.StatusReason = reason
+		u.StatusExpiresAt = expires
+		if err := tx.Users.UpdateUser(ctx, u); err != nil {
+			return err
+		}
+		if !u.Active() {
+			if err := tx.Sessions.DeleteByUserID(ctx, u.ID); err != nil {
+				return err
+			}
+		}
+		return us.audit.record(ctx, tx.Audit, event, u, metadata)
+	})
+}
+
+// DefaultDeletionGrace is how long an account whose owner asked for its
+// deletion can still be restored, unless configured otherwise.
+const DefaultDeletionGrace = 30 * 24 * time.Hour
+
+// RequestDeletion schedules the deletion of the user's account once grace
+// has passed. Meanwhile the user cannot sign in, their sessions end, and
+// CancelDeletion restores the account.
+func (us *UserService) RequestDeletion(ctx context.Context, user *repository.User, grace time.Duration) (err error) {
+	ctx, span := startSpan(ctx, "UserService.RequestDeletion")
+	defer func() { endSpan(span, err) }()
+
+	purgeAt := sql.NullTime{Time: time.Now().Add(grace), Valid: true}
+	return us.setStatus(ctx, user, EventDeletionRequested, "", repository.StatusPendingDeletion, "", purgeAt)
+}
+
+// CancelDeletion restores an account pending deletion.
+func (us *UserService) CancelDeletion(ctx context.Context, user *repository.User) (err error) {
+	ctx, span := startSpan(ctx, "UserService.CancelDeletion")
+	defer func() { endSpan(span, err) }()
+
+	return us.setStatus(ctx, user, EventDeletionCancelled, repository.StatusPendingDeletion, repository.StatusActive, "", sql.NullTime{})
+}
+
+// PurgeDeleted deletes the accounts pending deletion whose grace period is
+// over and reports how many there were.
+func (us *UserService) PurgeDeleted(ctx context.Context) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "UserService.PurgeDeleted")
+	defer func() { endSpan(span, err) }()
+
+	var n int64
+	opts := repository.ListUsersOptions{
+		Filter: repository.UserFilter{Status: repository.StatusPendingDeletion},
+		Limit:  MaxPageSize,
+	}
+	for {
+		page, err := us.UR.ListUsers(ctx, opts)
+		if err != nil {
+			return n, err
+		}
+		for _, u := range page.Users {
+			if u.StatusExpiresAt.Valid && u.StatusExpiresAt.Time.After(time.Now()) {
+				continue
+			}
+			if err := us.Delete(ctx, u); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if page.Next == "" {
+			return n, nil
+		}
+		opts.After = page.Next
+	}
 }
 
-func (us *UserService) Delete(ctx context.Context, user *repository.User) error {
-	return us.UR.DeleteUser(ctx, user.ID)
+// CheckPassword re-authenticates a signed-in user before a sensitive
+// action, failing with ErrInvalidCredentials.
+func (us *UserService) CheckPassword(ctx context.Context, user *repository.User, password string) (err error) {
+	ctx, span := startSpan(ctx, "UserService.CheckPassword")
+	defer func() { endSpan(span, err) }()
+
+	if !user.PasswordHash.Valid {
+		return ErrInvalidCredentials
+	}
+	ok, err := utils.CompareHash(user.PasswordHash.String, password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// VerifyEmail marks the user's email address as verified. Verifying an
+// already verified address keeps the original timestamp.
+func (us *UserService) VerifyEmail(ctx context.Context, user *repository.User) (err error) {
+	ctx, span := startSpan(ctx, "UserService.VerifyEmail")
+	defer func() { endSpan(span, err) }()
+
+	return us.update(ctx, user, EventEmailVerified, func(u *repository.User) (map[string]string, error) {
+		if u.EmailVerifiedAt.Valid {
+			return nil, errNoChange
+		}
+		u.EmailVerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		return nil, nil
+	})
+}
+
+// errNoChange is returned by a change that leaves the user as it is, so
+// that nothing is saved or recorded.
+var errNoChange = errors.New("no change")
+
+// update applies change to the user and saves the result in one unit of
+// work with the event recording it, with the metadata change returns.
+func (us *UserService) update(ctx context.Context, user *repository.User, event string, change func(u *repository.User) (map[string]string, error)) error {
+	return us.modify(ctx, user, func(ctx context.Context, tx Stores, u *repository.User) error {
+		metadata, err := change(u)
+		if err != nil {
+			return err
+		}
+		if err := tx.Users.UpdateUser(ctx, u); err != nil {
+			return err
+		}
+		return us.audit.record(ctx, tx.Audit, event, u, metadata)
+	})
+}
+
+// modify runs fn in a unit of work on the user as read within it, then
+// copies the result to user. Since UpdateUser writes every column, reading
+// the row afresh keeps fn from saving back fields, such as the status,
+// that someone else changed after user was loaded; a concurrent
+// modification fails the serializable transaction, which is retried on
+// the new row.
+func (us *UserService) modify(ctx context.Context, user *repository.User, fn func(ctx context.Context, tx Stores, u *repository.User) error) error {
+	var fresh *repository.User
+	err := us.tx.WithTx(ctx, func(ctx context.Context, tx Stores) error {
+		var err error
+		if fresh, err = tx.Users.GetUserByID(ctx, user.ID); err != nil {
+			return err
+		}
+		if fresh == nil {
+			return ErrUserNotFound
+		}
+		return fn(ctx, tx, fresh)
+	})
+	if err != nil && !errors.Is(err, errNoChange) {
+		return err
+	}
+	*user = *fresh
+	return nil
 }
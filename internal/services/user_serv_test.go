@@ -0,0 +1,419 @@
+package services_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"template/internal/repository"
+	"template/internal/repository/memory"
+	"template/internal/services"
+)
+
+func newUserService() *services.UserService {
+	store := memory.NewStore()
+	return services.NewUserService(store.Users(), store, nil, nil)
+}
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	us := newUserService()
+	ctx := t.Context()
+
+	u, err := us.Create(ctx, repository.User{
+		Email:        "ada@example.com",
+		PasswordHash: sql.NullString{String: "correct horse", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.PasswordHash.String == "correct horse" {
+		t.Fatal("password stored in clear")
+	}
+
+	if _, err := us.Create(ctx, repository.User{Email: "ada@example.com"}); !errors.Is(err, services.ErrEmailAlreadyExist) {
+		t.Errorf("duplicate Create error = %v, want ErrEmailAlreadyExist", err)
+	}
+
+	got, err := us.Authenticate(ctx, "ada@example.com", "correct horse")
+	if err != nil || got.ID != u.ID {
+		t.Errorf("Authenticate = %v, %v; want %s", got, err, u.ID)
+	}
+	for _, tc := range []struct{ email, password string }{
+		{"ada@example.com", "wrong password"},
+		{"nobody@example.com", "correct horse"},
+	} {
+		if _, err := us.Authenticate(ctx, tc.email, tc.password); !errors.Is(err, services.ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%s, %s) error = %v, want ErrInvalidCredentials", tc.email, tc.password, err)
+		}
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	us := newUserService()
+	ctx := t.Context()
+	u, err := us.Create(ctx, repository.User{Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := us.SetPassword(ctx, u, "short"); !errors.Is(err, services.ErrInvalidPassword) {
+		t.Errorf("SetPassword(short) error = %v, want ErrInvalidPassword", err)
+	}
+	if err := us.SetPassword(ctx, u, "a new passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate(ctx, "ada@example.com", "a new passphrase"); err != nil {
+		t.Errorf("Authenticate with the new password: %v", err)
+	}
+}
+
+func TestClearPassword(t *testing.T) {
+	store := memory.NewStore()
+	us := services.NewUserService(store.Users(), store, nil, nil)
+	ctx := t.Context()
+	u, err := us.Create(ctx, repository.User{Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := us.SetPassword(ctx, u, "a passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := us.ClearPassword(ctx, u); !errors.Is(err, services.ErrNoOtherSignIn) {
+		t.Errorf("ClearPassword without a linked identity error = %v, want ErrNoOtherSignIn", err)
+	}
+	if !u.PasswordHash.Valid {
+		t.Fatal("password cleared from an account with no other sign-in")
+	}
+
+	linked := *u
+	linked.GoogleID = sql.NullString{String: "g-123", Valid: true}
+	if err := store.Users().UpdateUser(ctx, &linked); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.ClearPassword(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate(ctx, "ada@example.com", "a passphrase"); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Errorf("Authenticate after ClearPassword error = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestUpdatesKeepConcurrentChanges(t *testing.T) {
+	us := newUserService()
+	ctx := t.Context()
+	u, err := us.Create(ctx, repository.User{Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The user saves their profile from a copy loaded before an admin
+	// suspended them and verified their email.
+	stale := *u
+	admin := *u
+	if err := us.Suspend(ctx, &admin, "spam", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.VerifyEmail(ctx, &admin); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.UpdateProfile(ctx, &stale, services.Profile{DisplayName: "Ada"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.RemoveAvatar(ctx, &stale); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := us.Get(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != repository.StatusSuspended || !got.EmailVerifiedAt.Valid || got.DisplayName != "Ada" {
+		t.Errorf("user = status %q, verified %v, name %q; want every change kept", got.Status, got.EmailVerifiedAt.Valid, got.DisplayName)
+	}
+	if stale.Status != repository.StatusSuspended {
+		t.Errorf("caller's copy has status %q, want it refreshed", stale.Status)
+	}
+}
+
+func TestRegisterConcurrentDuplicates(t *testing.T) {
+	store := memory.NewStore()
+	us := services.NewUserService(store.Users(), store, nil, nil)
+	ss := services.NewSessionService(store.Sessions(), store, nil)
+
+	const n = 8
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := us.Register(t.Context(), repository.User{
+				Email:        "ada@example.com",
+				PasswordHash: sql.NullString{String: "correct horse", Valid: true},
+			}, ss, nil, "test")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, services.ErrEmailAlreadyExist):
+			t.Errorf("Register error = %v, want ErrEmailAlreadyExist", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d registrations succeeded, want 1", created)
+	}
+}
+
+func TestRegisterIsAtomic(t *testing.T) {
+	store := memory.NewStore()
+	us := services.NewUserService(store.Users(), failingSessionsTx{store}, nil, nil)
+	ss := services.NewSessionService(store.Sessions(), store, nil)
+
+	_, _, err := us.Register(t.Context(), repository.User{Email: "ada@example.com"}, ss, nil, "test")
+	if !errors.Is(err, errDiskFull) {
+		t.Fatalf("Register error = %v, want %v", err, errDiskFull)
+	}
+	if got, err := us.GetByEmail(t.Context(), "ada@example.com"); got != nil || err != nil {
+		t.Errorf("user left behind by a failed Register: %v, %v", got, err)
+	}
+}
+
+var errDiskFull = errors.New("disk full")
+
+// failingSessionsTx runs units of work whose session writes fail.
+type failingSessionsTx struct{ *memory.Store }
+
+func (f failingSessionsTx) WithTx(ctx context.Context, fn func(context.Context, services.Stores) error) error {
+	return f.Store.WithTx(ctx, func(ctx context.Context, tx services.Stores) error {
+		tx.Sessions = failingSessions{tx.Sessions}
+		return fn(ctx, tx)
+	})
+}
+
+type failingSessions struct{ services.SessionStore }
+
+func (failingSessions) Create(context.Context, repository.Session) (string, error) {
+	return "", errDiskFull
+}
+
+func TestAccountStatus(t *testing.T) {
+	store := memory.NewStore()
+	us := services.NewUserService(store.Users(), store, nil, nil)
+	ss := services.NewSessionService(store.Sessions(), store, nil)
+	ctx := t.Context()
+
+	u, err := us.Create(ctx, repository.User{
+		Email:        "ada@example.com",
+		PasswordHash: sql.NullString{String: "correct horse", Valid: true},
+		GoogleID:     sql.NullString{String: "g-1", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := ss.CreateSession(ctx, u.ID, nil, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signIn := func() error {
+		_, err := us.Authenticate(ctx, "ada@example.com", "correct horse")
+		// Signing in with Google must be refused exactly when the password is.
+		if _, gerr := us.RegisterGoogleUser(ctx, &repository.GoogleUser{Id: "g-1", Email: u.Email}); (gerr == nil) != (err == nil) {
+			t.Errorf("Google sign-in error = %v, password sign-in error = %v", gerr, err)
+		}
+		return err
+	}
+
+	if err := us.Suspend(ctx, u, "spam", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.ValidateSession(ctx, cookie); err == nil {
+		t.Error("session survived the suspension")
+	}
+	var inactive *services.InactiveAccountError
+	if err := signIn(); !errors.As(err, &inactive) || inactive.User.StatusReason != "spam" {
+		t.Errorf("sign-in while suspended: error = %v, want an InactiveAccountError with the reason", err)
+	}
+
+	// A suspension with an end lapses on its own.
+	if err := us.Suspend(ctx, u, "cool off", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := signIn(); err != nil {
+		t.Errorf("sign-in after the suspension ended: %v", err)
+	}
+
+	if err := us.Ban(ctx, u, "fraud"); err != nil {
+		t.Fatal(err)
+	}
+	if err := signIn(); !errors.Is(err, services.ErrAccountInactive) {
+		t.Errorf("sign-in while banned: error = %v, want ErrAccountInactive", err)
+	}
+
+	if err := us.Reactivate(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if err := signIn(); err != nil {
+		t.Errorf("sign-in after reactivation: %v", err)
+	}
+	if u.StatusReason != "" || u.StatusExpiresAt.Valid {
+		t.Errorf("reactivated user keeps reason %q and expiry %v", u.StatusReason, u.StatusExpiresAt)
+	}
+}
+
+func TestGoogleSignInRestoresAccount(t *testing.T) {
+	us := newUserService()
+	ctx := t.Context()
+	u, err := us.RegisterGoogleUser(ctx, &repository.GoogleUser{Id: "g-1", Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := us.RequestDeletion(ctx, u, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := us.RegisterGoogleUser(ctx, &repository.GoogleUser{Id: "g-1", Email: "ada@example.com"})
+	if err != nil {
+		t.Fatalf("Google sign-in while pending deletion: %v", err)
+	}
+	if got.Status != repository.StatusActive || got.StatusExpiresAt.Valid {
+		t.Errorf("status = %q, expires %v, want the account restored", got.Status, got.StatusExpiresAt)
+	}
+	if n, err := us.PurgeDeleted(ctx); err != nil || n != 0 {
+		t.Errorf("PurgeDeleted = %d, %v, want the restored account kept", n, err)
+	}
+}
+
+func TestActionsFailWithTheirAuditEvent(t *testing.T) {
+	store := memory.NewStore()
+	tx := failingAuditTx{store}
+	audit := services.NewAuditService(store.Audit(), slog.New(slog.DiscardHandler))
+	us := services.NewUserService(store.Users(), tx, nil, audit)
+	rs := services.NewRoleService(store.Roles(), tx, audit)
+	ctx := t.Context()
+
+	if _, err := us.Create(ctx, repository.User{Email: "ada@example.com"}); !errors.Is(err, errDiskFull) {
+		t.Fatalf("Create error = %v, want %v", err, errDiskFull)
+	}
+	if got, err := us.GetByEmail(ctx, "ada@example.com"); got != nil || err != nil {
+		t.Errorf("user created without its audit event: %v, %v", got, err)
+	}
+
+	u, err := store.Users().CreateUser(ctx, &repository.User{Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := us.SetPassword(ctx, u, "correct horse"); !errors.Is(err, errDiskFull) {
+		t.Errorf("SetPassword error = %v, want %v", err, errDiskFull)
+	}
+	if u.PasswordHash.Valid {
+		t.Error("SetPassword changed the user despite failing")
+	}
+	if got, _ := store.Users().GetUserByID(ctx, u.ID); got.PasswordHash.Valid {
+		t.Error("password saved without its audit event")
+	}
+	if err := rs.Assign(ctx, u, repository.RoleAdmin); !errors.Is(err, errDiskFull) {
+		t.Errorf("Assign error = %v, want %v", err, errDiskFull)
+	}
+	if roles, _ := store.Roles().UserRoles(ctx, u.ID); len(roles) != 0 {
+		t.Errorf("role assigned without its audit event: %v", roles)
+	}
+}
+
+func TestFailedLoginForUnknownEmail(t *testing.T) {
+	store := memory.NewStore()
+	audit := services.NewAuditService(store.Audit(), slog.New(slog.DiscardHandler))
+	us := services.NewUserService(store.Users(), store, nil, audit)
+	ctx := t.Context()
+
+	attempts := []string{" Ada@Example.com ", "<script>@x", strings.Repeat("a", 1000) + "@example.com"}
+	for _, email := range attempts {
+		if _, err := us.Authenticate(ctx, email, "password"); !errors.Is(err, services.ErrInvalidCredentials) {
+			t.Fatalf("Authenticate(%q) error = %v", email, err)
+		}
+	}
+	events, err := audit.List(ctx, repository.AuditFilter{Type: services.EventLoginFailed, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(attempts) {
+		t.Fatalf("got %d events, want %d", len(events), len(attempts))
+	}
+	// Events are listed newest first.
+	if got := events[2].Metadata["email"]; got != "ada@example.com" {
+		t.Errorf("recorded email = %q, want it normalized", got)
+	}
+	for _, e := range events[:2] {
+		if got := e.Metadata["email"]; !strings.HasPrefix(got, "sha256:") || len(got) > 64 {
+			t.Errorf("recorded email = %q, want a short digest", got)
+		}
+	}
+}
+
+func TestPurgeDeletedForgetsEmail(t *testing.T) {
+	store := memory.NewStore()
+	audit := services.NewAuditService(store.Audit(), slog.New(slog.DiscardHandler))
+	us := services.NewUserService(store.Users(), store, nil, audit)
+	ctx := t.Context()
+
+	u, err := us.Create(ctx, repository.User{Email: "ada@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := us.SetPassword(ctx, u, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Authenticate(ctx, "ada@example.com", "wrong horse"); !errors.Is(err, services.ErrInvalidCredentials) {
+		t.Fatalf("Authenticate error = %v", err)
+	}
+	if err := us.RequestDeletion(ctx, u, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := us.PurgeDeleted(ctx); err != nil || n != 1 {
+		t.Fatalf("PurgeDeleted = %d, %v", n, err)
+	}
+
+	events, err := audit.List(ctx, repository.AuditFilter{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 {
+		t.Fatal("no events recorded")
+	}
+	for _, e := range events {
+		for k, v := range e.Metadata {
+			if strings.Contains(v, "ada@example.com") {
+				t.Errorf("%s event keeps the email in %s", e.Type, k)
+			}
+		}
+	}
+}
+
+// failingAuditTx runs units of work whose audit writes fail.
+type failingAuditTx struct{ *memory.Store }
+
+func (f failingAuditTx) WithTx(ctx context.Context, fn func(context.Context, services.Stores) error) error {
+	return f.Store.WithTx(ctx, func(ctx context.Context, tx services.Stores) error {
+		tx.Audit = failingAudit{tx.Audit}
+		return fn(ctx, tx)
+	})
+}
+
+type failingAudit struct{ services.AuditStore }
+
+func (failingAudit) RecordAuditEvent(context.Context, *repository.AuditEvent) error {
+	return errDiskFull
+}
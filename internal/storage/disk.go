@@ -0,0 +1,84 @@
+// Package storage keeps uploaded files, such as avatars, outside the
+// database.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Disk stores each file under its key in a directory of the local disk,
+// created on first write. Keys are single path elements. The context
+// arguments are unused; they are there for stores behind a network.
+type Disk struct {
+	dir string
+}
+
+func NewDisk(dir string) *Disk {
+	return &Disk{dir: dir}
+}
+
+// path returns where key is stored, refusing keys that would leave dir.
+func (d *Disk) path(op, key string) (string, error) {
+	if key == "" || key == "." || !filepath.IsLocal(key) || strings.ContainsAny(key, `/\`) {
+		return "", &fs.PathError{Op: op, Path: key, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(d.dir, key), nil
+}
+
+// Put stores the content of r under key, replacing any previous file. The
+// file appears whole or not at all.
+func (d *Disk) Put(_ context.Context, key string, r io.Reader) (err error) {
+	path, err := d.path("put", key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.dir, 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(d.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("storage: write %s: %w", key, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Open returns the file stored under key. An unknown key fails with an
+// error matching fs.ErrNotExist.
+func (d *Disk) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	path, err := d.path("open", key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Delete removes the file stored under key. Deleting an unknown key is not
+// an error.
+func (d *Disk) Delete(_ context.Context, key string) error {
+	path, err := d.path("delete", key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
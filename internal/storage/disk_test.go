@@ -0,0 +1,86 @@
+package storage_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"template/internal/storage"
+)
+
+func TestDiskKeys(t *testing.T) {
+	d := storage.NewDisk(t.TempDir())
+	ctx := t.Context()
+	for _, key := range []string{"", "../x", "a/b", `a\b`, "."} {
+		if err := d.Put(ctx, key, strings.NewReader("x")); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Put(%q) error = %v, want fs.ErrInvalid", key, err)
+		}
+		if _, err := d.Open(ctx, key); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Open(%q) error = %v, want fs.ErrInvalid", key, err)
+		}
+		if err := d.Delete(ctx, key); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Delete(%q) error = %v, want fs.ErrInvalid", key, err)
+		}
+	}
+}
+
+func TestDiskPutOpenDelete(t *testing.T) {
+	dir := t.TempDir()
+	d := storage.NewDisk(dir)
+	ctx := t.Context()
+
+	for _, content := range []string{"first", "second"} {
+		if err := d.Put(ctx, "avatar", strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, d, "avatar"); got != content {
+			t.Errorf("content = %q, want %q", got, content)
+		}
+	}
+
+	// A failed write leaves the previous file and no temporary file.
+	if err := d.Put(ctx, "avatar", io.MultiReader(strings.NewReader("partial"), failingReader{})); err == nil {
+		t.Error("Put with a failing reader succeeded")
+	}
+	if got := read(t, d, "avatar"); got != "second" {
+		t.Errorf("content after a failed Put = %q, want second", got)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "avatar" {
+		t.Errorf("directory holds %v, want only avatar", entries)
+	}
+
+	if err := d.Delete(ctx, "avatar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Open(ctx, "avatar"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open after Delete error = %v, want fs.ErrNotExist", err)
+	}
+	if err := d.Delete(ctx, "avatar"); err != nil {
+		t.Errorf("Delete of a missing key = %v, want nil", err)
+	}
+}
+
+func read(t *testing.T, d *storage.Disk, key string) string {
+	t.Helper()
+	f, err := d.Open(t.Context(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
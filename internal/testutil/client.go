@@ -0,0 +1,201 @@
+package testutil
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+
+	"template/internal/repository"
+)
+
+// Client is a browser-like client of an App: it keeps cookies, follows
+// redirects and adds the CSRF token to the forms it posts.
+type Client struct {
+	t    *testing.T
+	app  *App
+	http *http.Client
+}
+
+// Response is a fully read response. URL is where the redirects ended.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	URL        *url.URL
+	Body       string
+}
+
+// Client returns a client with an empty cookie jar.
+func (a *App) Client(t *testing.T) *Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Server.Client returns the same client every time; copy it so that
+	// each Client keeps its own jar.
+	c := *a.Server.Client()
+	c.Jar = jar
+	return &Client{t: t, app: a, http: &c}
+}
+
+// Get requests path, relative to the server root.
+func (c *Client) Get(path string) *Response {
+	c.t.Helper()
+	req, err := http.NewRequestWithContext(c.t.Context(), http.MethodGet, c.app.Server.URL+path, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return c.do(req)
+}
+
+// PostForm posts form to path. The csrf_token field is filled from the
+// cookie jar unless form already has one.
+func (c *Client) PostForm(path string, form url.Values) *Response {
+	c.t.Helper()
+	if form == nil {
+		form = url.Values{}
+	}
+	if !form.Has("csrf_token") {
+		if token := c.Cookie("csrf_token"); token != "" {
+			form.Set("csrf_token", token)
+		}
+	}
+	req, err := http.NewRequestWithContext(c.t.Context(), http.MethodPost, c.app.Server.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.do(req)
+}
+
+// Upload posts content as the file field of a multipart form, with the
+// CSRF token.
+func (c *Client) Upload(path, field, filename string, content []byte) *Response {
+	c.t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if token := c.Cookie("csrf_token"); token != "" {
+		_ = mw.WriteField("csrf_token", token)
+	}
+	fw, err := mw.CreateFormFile(field, filename)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	_, _ = fw.Write(content)
+	if err := mw.Close(); err != nil {
+		c.t.Fatal(err)
+	}
+	req, err := http.NewRequestWithContext(c.t.Context(), http.MethodPost, c.app.Server.URL+path, &body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return c.do(req)
+}
+
+// Cookie returns the value of the named cookie in the jar, or "".
+func (c *Client) Cookie(name string) string {
+	u, _ := url.Parse(c.app.Server.URL)
+	for _, cookie := range c.http.Jar.Cookies(u) {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// Register signs up through the registration form, loading its page
+// first for the CSRF token.
+func (c *Client) Register(email, password string) *Response {
+	c.t.Helper()
+	c.Get("/register")
+	return c.PostForm("/register", url.Values{
+		"email":    {email},
+		"password": {password},
+		"repeat":   {password},
+	})
+}
+
+// Login signs in through the login form, loading its page first for the
+// CSRF token.
+func (c *Client) Login(email, password string) *Response {
+	c.t.Helper()
+	c.Get("/login")
+	return c.PostForm("/login", url.Values{
+		"email":    {email},
+		"password": {password},
+	})
+}
+
+// Logout signs out through the logout form.
+func (c *Client) Logout() *Response {
+	c.t.Helper()
+	return c.PostForm("/logout", nil)
+}
+
+// ActAs returns a client signed in as a new user with email, creating the
+// user and session directly through the services rather than the forms.
+func (a *App) ActAs(t *testing.T, email string) (*Client, *repository.User) {
+	t.Helper()
+	user, err := a.Users.Create(t.Context(), repository.User{Email: email})
+	if err != nil {
+		t.Fatalf("create %s: %v", email, err)
+	}
+	cookie, err := a.Sessions.CreateSession(t.Context(), user.ID, nil, "testutil")
+	if err != nil {
+		t.Fatalf("create session for %s: %v", email, err)
+	}
+
+	c := a.Client(t)
+	u, _ := url.Parse(a.Server.URL)
+	c.http.Jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: cookie, Path: "/"}})
+	return c, user
+}
+
+// AssertStatus fails the test unless the response has status code.
+func (r *Response) AssertStatus(t *testing.T, code int) {
+	t.Helper()
+	if r.StatusCode != code {
+		t.Fatalf("%s: status %d, want %d\n%s", r.URL, r.StatusCode, code, r.Body)
+	}
+}
+
+// AssertPath fails the test unless the redirects ended at path.
+func (r *Response) AssertPath(t *testing.T, path string) {
+	t.Helper()
+	if r.URL.Path != path {
+		t.Fatalf("ended at %s, want %s", r.URL.Path, path)
+	}
+}
+
+// AssertContains fails the test unless the body contains s.
+func (r *Response) AssertContains(t *testing.T, s string) {
+	t.Helper()
+	if !strings.Contains(r.Body, s) {
+		t.Fatalf("%s: body does not contain %q:\n%s", r.URL, s, r.Body)
+	}
+}
+
+func (c *Client) do(req *http.Request) *Response {
+	c.t.Helper()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatalf("%s %s: read body: %v", req.Method, req.URL.Path, err)
+	}
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		URL:        resp.Request.URL,
+		Body:       string(body),
+	}
+}
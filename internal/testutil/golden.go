@@ -0,0 +1,48 @@
+package testutil
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current output")
+
+// volatile matches the per-request values in rendered pages.
+var volatile = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`nonce="[^"]*"`), `nonce="NONCE"`},
+	{regexp.MustCompile(`(name="csrf_token" value=)"[^"]*"`), `$1"CSRF"`},
+}
+
+// Golden compares body, with nonces and CSRF tokens masked, against
+// testdata/name.golden. Run the tests with -update to rewrite the file.
+func Golden(t *testing.T, name, body string) {
+	t.Helper()
+	for _, v := range volatile {
+		body = v.re.ReplaceAllString(body, v.repl)
+	}
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if body != string(want) {
+		t.Errorf("%s differs from the golden file; run with -update to accept.\n got:\n%s\nwant:\n%s", path, body, want)
+	}
+}
@@ -0,0 +1,110 @@
+// Package testutil runs the full application handler in tests, over either
+// in-memory stores or a throwaway Postgres schema, and drives it with a
+// browser-like client.
+package testutil
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"template/config"
+	"template/internal/health"
+	"template/internal/repository"
+	"template/internal/repository/memory"
+	"template/internal/server"
+	"template/internal/services"
+	"template/internal/storage"
+)
+
+// Backend selects the stores behind an App.
+type Backend string
+
+const (
+	Memory   Backend = "memory"
+	Postgres Backend = "postgres"
+)
+
+// App is the application served over TLS by an httptest server.
+type App struct {
+	Server *httptest.Server
+	Config *config.Config
+
+	Users    *services.UserService
+	Sessions *services.SessionService
+	Roles    *services.RoleService
+	Audit    *services.AuditService
+}
+
+// New starts the application on backend. The Postgres backend needs
+// TEST_DATABASE_URL and skips the test without it.
+func New(t *testing.T, backend Backend) *App {
+	t.Helper()
+
+	cfg, _, err := config.Load(nil, nil)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	cfg.Storage.Dir = t.TempDir()
+
+	logger := slog.New(slog.DiscardHandler)
+	var stores services.Stores
+	var tx services.Transactor
+	switch backend {
+	case Memory:
+		s := memory.NewStore()
+		stores = services.Stores{Users: s.Users(), Sessions: s.Sessions(), Impersonations: s.Impersonations(), Roles: s.Roles(), Audit: s.Audit()}
+		tx = s
+	case Postgres:
+		conn := PostgresSchema(t)
+		stores = services.Stores{
+			Users:          repository.NewUserRepo(conn, logger),
+			Sessions:       &repository.SessionRepository{DB: conn},
+			Impersonations: &repository.ImpersonationRepository{DB: conn},
+			Roles:          &repository.RoleRepository{DB: conn},
+			Audit:          &repository.AuditRepository{DB: conn},
+		}
+		tx = services.SQLTransactor{DB: conn, Logger: logger}
+	default:
+		t.Fatalf("unknown backend %q", backend)
+	}
+
+	hr := server.NewHandlerRegistery(stores, tx, logger, nil, health.NewChecker(time.Second, logger), cfg)
+	srv := httptest.NewTLSServer(hr.Routes())
+	t.Cleanup(srv.Close)
+
+	as := services.NewAuditService(stores.Audit, logger)
+	return &App{
+		Server:   srv,
+		Config:   cfg,
+		Users:    services.NewUserService(stores.Users, tx, storage.NewDisk(cfg.Storage.Dir), as),
+		Sessions: services.NewSessionService(stores.Sessions, tx, as),
+		Roles:    services.NewRoleService(stores.Roles, tx, as),
+		Audit:    as,
+	}
+}
+
+// Run runs fn as a subtest against every backend; the Postgres one is
+// skipped without TEST_DATABASE_URL.
+func Run(t *testing.T, fn func(t *testing.T, app *App)) {
+	for _, backend := range []Backend{Memory, Postgres} {
+		t.Run(string(backend), func(t *testing.T) {
+			fn(t, New(t, backend))
+		})
+	}
+}
+
+// PostgresSchema creates and migrates a schema in the database named by
+// TEST_DATABASE_URL and returns a connection whose search_path starts with
+// it. The schema is dropped when the test ends.
+func PostgresSchema(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	return openSchema(t, dsn)
+}
@@ -2,52 +2,123 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
 	"os/signal"
-	"sync"
+	"strings"
 
 	"template/config"
 	"template/db"
+	"template/internal/repository"
+	"template/internal/services"
 )
 
+const usage = `usage: template [command] [flags]
+
+commands:
+  serve                run the HTTP servers (the default)
+  migrate <command>    apply, roll back or create database migrations
+  user <command>       create, list, delete, verify or reset accounts
+  sessions <command>   purge expired sessions or revoke a user's sessions
+  roles <command>      list roles, or grant and revoke them
+  audit <command>      purge audit events older than the retention period
+  config <command>     check or print the effective configuration
+
+Every command accepts the configuration flags; "<command> -h" lists them.`
+
 func main() {
-	cfg := config.Load()
-	logger := config.NewSlog(cfg.Env)
+	args := os.Args[1:]
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
 
-	conn, err := db.NewDB(cfg.Database.String())
-	if err != nil {
-		logger.Error("db connection failed", slog.String("error", err.Error()))
-		return
+	switch cmd {
+	case "serve":
+		cfg, _, code := loadConfig(flag.NewFlagSet("serve", flag.ContinueOnError), args)
+		if cfg == nil {
+			os.Exit(code)
+		}
+		os.Exit(runServe(cfg))
+	case "migrate":
+		os.Exit(runMigrate(args))
+	case "user":
+		os.Exit(runUser(args))
+	case "sessions":
+		os.Exit(runSessions(args))
+	case "roles":
+		os.Exit(runRoles(args))
+	case "audit":
+		os.Exit(runAudit(args))
+	case "config":
+		os.Exit(runConfig(args))
+	case "help":
+		fmt.Println(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", cmd, usage)
+		os.Exit(2)
 	}
-	defer conn.Close()
+}
 
-	hr := NewHandlerRegistery(conn, logger)
+// loadConfig parses a command's flags together with the configuration
+// flags and validates the result. On failure it reports the error and
+// returns a nil config with the exit code to use.
+func loadConfig(fs *flag.FlagSet, args []string) (*config.Config, []string, int) {
+	cfg, rest, err := config.Load(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil, nil, 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, 2
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return nil, nil, 1
+	}
+	return cfg, rest, 0
+}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer cancel()
+func dbOptions(cfg *config.Config, logger *slog.Logger) db.Options {
+	return db.Options{
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
+		ConnMaxIdleTime: cfg.Database.ConnMaxIdleTime,
+		ConnectTimeout:  cfg.Database.ConnectTimeout,
+		Logger:          logger,
+	}
+}
 
-	serverErr := make(chan error, 1)
-	var wg sync.WaitGroup
-	defer wg.Wait()
+// cliLogger is the logger of one-off commands, which write to stderr.
+var cliLogger = slog.New(slog.NewTextHandler(os.Stderr, nil))
 
-	s := &http.Server{
-		Addr:     ":80",
-		Handler:  hr.Routes(),
-		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
-	}
+// openDB connects for one-off commands.
+func openDB(cfg *config.Config) (*sql.DB, error) {
+	return db.NewDB(cfg.Database.String(), dbOptions(cfg, cliLogger))
+}
 
-	wg.Add(1)
-	go startServer(s, serverErr, &wg)
+// cliAudit records what one-off commands do. Their events have no actor.
+func cliAudit(conn *sql.DB) *services.AuditService {
+	return services.NewAuditService(&repository.AuditRepository{DB: conn}, cliLogger)
+}
 
-	select {
-	case err := <-serverErr:
-		logger.Error("server failed", slog.String("error", err.Error()))
-	case <-ctx.Done():
-	}
+// withDB adapts a function over a connection into a command. The context
+// is cancelled on SIGINT.
+func withDB(fn func(context.Context, *sql.DB) error) func(context.Context, *config.Config) error {
+	return func(ctx context.Context, cfg *config.Config) error {
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
 
-	if err := gracefulShutdown(ctx, s); err != nil {
-		logger.Error("server failed to shutdown", slog.String("error", err.Error()))
+		conn, err := openDB(cfg)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return fn(ctx, conn)
 	}
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"template/config"
+	"template/db"
+)
+
+const migrateUsage = `usage: migrate <command> [flags]
+
+commands:
+  up             apply all pending migrations
+  down           roll back the latest migration
+  redo           roll back the latest migration and apply it again
+  status         list migrations and whether they are applied
+  create <name>  write a new empty migration to ` + db.MigrationsDir
+
+// runMigrate implements the migrate subcommands. Flags after the command
+// are configuration overrides, as for serving.
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	cmd, args := args[0], args[1:]
+
+	if cmd == "create" {
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "usage: migrate create <name>")
+			return 2
+		}
+		if err := db.CreateMigration(db.MigrationsDir, args[0]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	var run func(context.Context, *config.Config) error
+	switch cmd {
+	case "up":
+		run = withDB(db.Migrate)
+	case "down":
+		run = withDB(db.MigrateDown)
+	case "redo":
+		run = withDB(db.MigrateRedo)
+	case "status":
+		run = withDB(func(ctx context.Context, conn *sql.DB) error {
+			return db.MigrationStatus(ctx, conn, os.Stdout)
+		})
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	cfg, rest, code := loadConfig(flag.NewFlagSet("migrate "+cmd, flag.ContinueOnError), args)
+	if cfg == nil {
+		return code
+	}
+	if len(rest) > 0 {
+		fmt.Fprintf(os.Stderr, "migrate %s takes no arguments\n", cmd)
+		return 2
+	}
+	if err := run(context.Background(), cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"template/internal/repository"
+	"template/internal/services"
+)
+
+const rolesUsage = `usage: roles <command> [flags]
+
+commands:
+  list                         list the roles and their permissions
+  grant <role> -user <email>   give an account a role
+  revoke <role> -user <email>  take a role away from an account`
+
+// runRoles implements the roles subcommands.
+func runRoles(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, rolesUsage)
+		return 2
+	}
+	cmd, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("roles "+cmd, flag.ContinueOnError)
+	var email *string
+	want := 0
+	switch cmd {
+	case "list":
+	case "grant", "revoke":
+		email = fs.String("user", "", "email of the account")
+		want = 1
+	default:
+		fmt.Fprintln(os.Stderr, rolesUsage)
+		return 2
+	}
+
+	cfg, rest, code := loadConfig(fs, args)
+	if cfg == nil {
+		return code
+	}
+	if len(rest) != want || email != nil && *email == "" {
+		fmt.Fprintln(os.Stderr, rolesUsage)
+		return 2
+	}
+
+	err := withDB(func(ctx context.Context, conn *sql.DB) error {
+		tx := services.SQLTransactor{DB: conn, Logger: cliLogger}
+		rs := services.NewRoleService(&repository.RoleRepository{DB: conn}, tx, cliAudit(conn))
+		if cmd == "list" {
+			return listRoles(ctx, rs)
+		}
+
+		us := services.NewUserService(repository.NewUserRepo(conn, cliLogger), tx, nil, cliAudit(conn))
+		user, err := findUser(ctx, us, *email)
+		if err != nil {
+			return err
+		}
+		role := rest[0]
+		if cmd == "revoke" {
+			if err := rs.Unassign(ctx, user, role); err != nil {
+				return err
+			}
+			fmt.Printf("revoked role %s from %s\n", role, user.Email)
+			return nil
+		}
+		if err := rs.Assign(ctx, user, role); err != nil {
+			return err
+		}
+		fmt.Printf("granted role %s to %s\n", role, user.Email)
+		return nil
+	})(context.Background(), cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func listRoles(ctx context.Context, rs *services.RoleService) error {
+	roles, err := rs.Roles(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROLE\tPERMISSIONS\tDESCRIPTION")
+	for _, r := range roles {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Name, strings.Join(r.Permissions, ","), r.Description)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"template/config"
+	"template/db"
+	"template/internal/handlers"
+	"template/internal/health"
+	"template/internal/lifecycle"
+	"template/internal/metrics"
+	"template/internal/repository"
+	"template/internal/server"
+	"template/internal/services"
+	"template/internal/tracing"
+)
+
+// runServe runs the HTTP servers until a signal arrives or one of them fails.
+func runServe(cfg *config.Config) int {
+	level := config.NewLevel(cfg.LogLevel)
+	logger := config.NewSlog(cfg.Env, level)
+
+	// Components register their stop function as they start; they are
+	// stopped in reverse order once runServe returns.
+	shutdown := lifecycle.NewShutdown(logger)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+		defer cancel()
+		if err := shutdown.Run(ctx); err != nil {
+			logger.Error("shutdown incomplete", slog.String("error", err.Error()))
+		}
+	}()
+
+	conn, err := db.NewDB(cfg.Database.String(), dbOptions(cfg, logger))
+	if err != nil {
+		logger.Error("db connection failed", slog.String("error", err.Error()))
+		return 1
+	}
+	shutdown.Add("database", func(context.Context) error { return conn.Close() })
+
+	if cfg.Database.AutoMigrate {
+		if err := db.Migrate(context.Background(), conn); err != nil {
+			logger.Error("migration failed", slog.String("error", err.Error()))
+			return 1
+		}
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: cfg.Tracing.ServiceName,
+		Exporter:    cfg.Tracing.Exporter,
+		File:        cfg.Tracing.File,
+	})
+	if err != nil {
+		logger.Error("tracing setup failed", slog.String("error", err.Error()))
+		return 1
+	}
+	shutdown.Add("tracing", shutdownTracing)
+
+	workers := lifecycle.NewWorkers()
+	shutdown.Add("background workers", workers.Stop)
+
+	checkMigrations, err := db.MigrationCheck(conn)
+	if err != nil {
+		logger.Error("migration check setup failed", slog.String("error", err.Error()))
+		return 1
+	}
+	hc := health.NewChecker(2*time.Second, logger)
+	hc.Register("database", conn.PingContext)
+	hc.Register("migrations", checkMigrations)
+
+	m := metrics.New(conn)
+	hr := server.NewHandlerRegistery(
+		services.Stores{
+			Users:          repository.NewUserRepo(conn, logger),
+			Sessions:       &repository.SessionRepository{DB: conn},
+			Impersonations: &repository.ImpersonationRepository{DB: conn},
+			Roles:          &repository.RoleRepository{DB: conn},
+			Audit:          &repository.AuditRepository{DB: conn},
+		},
+		services.SQLTransactor{DB: conn, Logger: logger},
+		logger, m, hc, cfg,
+	)
+
+	if interval := cfg.Server.MaintenanceInterval; interval > 0 {
+		workers.Every(interval, func(ctx context.Context) {
+			runMaintenance(ctx, hr.UserHandler, cfg, logger)
+		})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		logger.Error("tls setup failed", slog.String("error", err.Error()))
+		return 1
+	}
+
+	s := &http.Server{
+		Addr:              cfg.Addr(),
+		TLSConfig:         tlsConfig,
+		Handler:           hr.Routes(),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+
+	admin := &http.Server{
+		Addr:              cfg.AdminAddr,
+		Handler:           AdminRoutes(m, level, logger),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+
+	servers := []*http.Server{s, admin}
+	if tlsConfig != nil && cfg.TLS.RedirectAddr != "" {
+		servers = append(servers, &http.Server{
+			Addr:              cfg.TLS.RedirectAddr,
+			Handler:           redirectToHTTPS(cfg.Port),
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+			ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		})
+	}
+
+	serverErr := make(chan error, len(servers))
+	for _, srv := range servers {
+		go startServer(srv, serverErr)
+	}
+	shutdown.Add("http servers", shutdownServers(servers...))
+	shutdown.Add("readiness", drainReadiness(hc, cfg.Server.ShutdownDelay))
+	logger.Info("server started", slog.String("addr", s.Addr), slog.Bool("tls", tlsConfig != nil))
+
+	code := 0
+	select {
+	case err := <-serverErr:
+		logger.Error("server failed", slog.String("error", err.Error()))
+		code = 1
+	case <-ctx.Done():
+		logger.Info("shutdown signal received", slog.Duration("drain_timeout", cfg.Server.DrainTimeout))
+	}
+
+	// The second handler is installed before the first is removed, so no
+	// signal falls back to the default action in between.
+	forceExitOnSignal(logger)
+	stop()
+	return code
+}
+
+// runMaintenance purges expired sessions, impersonations, deleted accounts
+// and audit events. Failures are logged and retried on the next run.
+func runMaintenance(ctx context.Context, uh handlers.UserHandler, cfg *config.Config, logger *slog.Logger) {
+	jobs := []struct {
+		name string
+		run  func(context.Context) (int64, error)
+	}{
+		{"expired sessions", uh.SS.PurgeExpired},
+		{"expired impersonations", uh.IS.PurgeExpired},
+		{"deleted accounts", uh.US.PurgeDeleted},
+		{"audit events", func(ctx context.Context) (int64, error) {
+			return uh.AS.Purge(ctx, cfg.Audit.Retention)
+		}},
+	}
+	for _, job := range jobs {
+		n, err := job.run(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.ErrorContext(ctx, "maintenance failed", slog.String("job", job.name), slog.String("error", err.Error()))
+			}
+			continue
+		}
+		if n > 0 {
+			logger.InfoContext(ctx, "maintenance", slog.String("job", job.name), slog.Int64("purged", n))
+		}
+	}
+}
+
+// forceExitOnSignal exits immediately if another SIGINT or SIGTERM arrives
+// while the application is draining.
+func forceExitOnSignal(logger *slog.Logger) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		logger.Error("second signal received, forcing exit")
+		os.Exit(1)
+	}()
+}
+
+func startServer(s *http.Server, serverErr chan error) {
+	var err error
+	if s.TLSConfig != nil {
+		err = s.ListenAndServeTLS("", "")
+	} else {
+		err = s.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		serverErr <- err
+	}
+}
+
+// drainReadiness marks the instance as not ready and waits delay for load
+// balancers to stop routing to it, before the servers are shut down.
+func drainReadiness(hc *health.Checker, delay time.Duration) func(context.Context) error {
+	return func(ctx context.Context) error {
+		hc.Shutdown()
+		select {
+		case <-time.After(delay):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// shutdownServers stops accepting connections and waits for in-flight
+// requests, until ctx expires.
+func shutdownServers(servers ...*http.Server) func(context.Context) error {
+	return func(ctx context.Context) error {
+		var errs []error
+		for _, s := range servers {
+			if err := s.Shutdown(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("server %s failed to shutdown: %w", s.Addr, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"template/internal/repository"
+	"template/internal/services"
+)
+
+const sessionsUsage = `usage: sessions <command> [flags]
+
+commands:
+  purge                 delete every expired session and impersonation
+  revoke -user <email>  sign an account out everywhere`
+
+// runSessions implements the sessions subcommands.
+func runSessions(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, sessionsUsage)
+		return 2
+	}
+	cmd, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("sessions "+cmd, flag.ContinueOnError)
+	var email *string
+	switch cmd {
+	case "purge":
+	case "revoke":
+		email = fs.String("user", "", "email of the account whose sessions are revoked")
+	default:
+		fmt.Fprintln(os.Stderr, sessionsUsage)
+		return 2
+	}
+
+	cfg, rest, code := loadConfig(fs, args)
+	if cfg == nil {
+		return code
+	}
+	if len(rest) > 0 || email != nil && *email == "" {
+		fmt.Fprintln(os.Stderr, sessionsUsage)
+		return 2
+	}
+
+	err := withDB(func(ctx context.Context, conn *sql.DB) error {
+		tx := services.SQLTransactor{DB: conn, Logger: cliLogger}
+		ss := services.NewSessionService(&repository.SessionRepository{DB: conn}, tx, cliAudit(conn))
+		if cmd == "purge" {
+			n, err := ss.PurgeExpired(ctx)
+			if err != nil {
+				return err
+			}
+			is := services.NewImpersonationService(&repository.ImpersonationRepository{DB: conn}, nil, tx, nil)
+			m, err := is.PurgeExpired(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("purged %d expired sessions and %d expired impersonations\n", n, m)
+			return nil
+		}
+
+		us := services.NewUserService(repository.NewUserRepo(conn, cliLogger), tx, nil, cliAudit(conn))
+		user, err := findUser(ctx, us, *email)
+		if err != nil {
+			return err
+		}
+		if err := ss.RevokeAllUserSessions(ctx, user.ID); err != nil {
+			return err
+		}
+		fmt.Printf("revoked every session of %s\n", user.Email)
+		return nil
+	})(context.Background(), cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"template/config"
+	"template/internal/certs"
+)
+
+// newTLSConfig builds the main listener's TLS config from certificate
+// files, or from a cached self-signed certificate in development.
+// It returns nil when TLS is disabled.
+func newTLSConfig(cfg *config.TLS) (*tls.Config, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	certFile, keyFile := cfg.CertFile, cfg.KeyFile
+	if cfg.SelfSigned {
+		var err error
+		certFile, keyFile, err = certs.SelfSigned(cfg.CacheDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reloader, err := certs.NewReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// redirectToHTTPS sends every request to the same host and path on the
+// HTTPS port.
+func redirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
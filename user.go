@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"template/internal/repository"
+	"template/internal/services"
+	"template/internal/storage"
+	"template/utils"
+
+	"golang.org/x/term"
+)
+
+const userUsage = `usage: user <command> [flags]
+
+commands:
+  create <email> [-verified]  create a password account
+  list                        list accounts, filtered by -email, -provider and
+                              -status and ordered by -sort and -desc
+  delete <email>              delete an account and its sessions
+  set-password <email>        replace the password of an account
+  verify <email>              mark the email address of an account as verified
+  purge-deleted               delete the accounts whose deletion grace period
+                              is over
+
+Passwords are prompted for on a terminal, or read as one line from stdin.`
+
+// runUser implements the user subcommands through UserService, so accounts
+// created here follow the same rules as those created over HTTP.
+func runUser(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+	cmd, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("user "+cmd, flag.ContinueOnError)
+	var run func(context.Context, *services.UserService, []string) error
+	switch cmd {
+	case "create":
+		verified := fs.Bool("verified", false, "mark the email address as verified")
+		run = func(ctx context.Context, us *services.UserService, args []string) error {
+			return createUser(ctx, us, args, *verified)
+		}
+	case "list":
+		var opts repository.ListUsersOptions
+		fs.StringVar(&opts.Filter.Email, "email", "", "only list emails containing this text")
+		fs.StringVar(&opts.Filter.Provider, "provider", "", "only list users who sign in with password or google")
+		fs.StringVar(&opts.Filter.Status, "status", "", "only list users with this status: active, suspended, banned or pending_deletion")
+		fs.StringVar(&opts.Sort, "sort", repository.SortCreatedAt, "sort by created_at or email")
+		fs.BoolVar(&opts.Desc, "desc", false, "sort in descending order")
+		run = func(ctx context.Context, us *services.UserService, _ []string) error {
+			return listUsers(ctx, us, opts)
+		}
+	case "delete":
+		run = deleteUser
+	case "set-password":
+		run = setPassword
+	case "verify":
+		run = verifyUser
+	case "purge-deleted":
+		run = purgeDeleted
+	default:
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+
+	cfg, rest, code := loadConfig(fs, args)
+	if cfg == nil {
+		return code
+	}
+	want := 1
+	if cmd == "list" || cmd == "purge-deleted" {
+		want = 0
+	}
+	if len(rest) != want {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+
+	err := withDB(func(ctx context.Context, conn *sql.DB) error {
+		us := services.NewUserService(repository.NewUserRepo(conn, cliLogger), services.SQLTransactor{DB: conn, Logger: cliLogger}, storage.NewDisk(cfg.Storage.Dir), cliAudit(conn))
+		return run(ctx, us, rest)
+	})(context.Background(), cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func createUser(ctx context.Context, us *services.UserService, args []string, verified bool) error {
+	email := utils.CleanString(args[0])
+	if !utils.IsValidEmail(email) {
+		return fmt.Errorf("invalid email address %q", email)
+	}
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if !utils.IsValidPasswordLength(password) {
+		return services.ErrInvalidPassword
+	}
+
+	user, err := us.Create(ctx, repository.User{
+		Email:        email,
+		PasswordHash: sql.NullString{String: password, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	if verified {
+		if err := us.VerifyEmail(ctx, user); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("created user %s (%s)\n", user.Email, user.ID)
+	return nil
+}
+
+// listUsers prints every matching user, fetching them a page at a time.
+func listUsers(ctx context.Context, us *services.UserService, opts repository.ListUsersOptions) error {
+	opts.Limit = services.MaxPageSize
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tSTATUS\tLOGIN\tVERIFIED\tCREATED")
+	var total int
+	for {
+		page, err := us.List(ctx, opts)
+		if err != nil {
+			return err
+		}
+		total = page.Total
+		for _, u := range page.Users {
+			var login []string
+			if u.PasswordHash.Valid {
+				login = append(login, repository.ProviderPassword)
+			}
+			if u.GoogleID.Valid {
+				login = append(login, repository.ProviderGoogle)
+			}
+			verified := "no"
+			if u.EmailVerifiedAt.Valid {
+				verified = u.EmailVerifiedAt.Time.Format(time.DateOnly)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				u.ID, u.Email, u.Status, strings.Join(login, ","), verified, u.CreatedAt.Format(time.DateOnly))
+		}
+		if page.Next == "" {
+			break
+		}
+		opts.After = page.Next
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d users\n", total)
+	return nil
+}
+
+func deleteUser(ctx context.Context, us *services.UserService, args []string) error {
+	user, err := findUser(ctx, us, args[0])
+	if err != nil {
+		return err
+	}
+	if err := us.Delete(ctx, user); err != nil {
+		return err
+	}
+	fmt.Printf("deleted user %s\n", user.Email)
+	return nil
+}
+
+func setPassword(ctx context.Context, us *services.UserService, args []string) error {
+	user, err := findUser(ctx, us, args[0])
+	if err != nil {
+		return err
+	}
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if err := us.SetPassword(ctx, user, password); err != nil {
+		return err
+	}
+	fmt.Printf("password updated for %s\n", user.Email)
+	return nil
+}
+
+func verifyUser(ctx context.Context, us *services.UserService, args []string) error {
+	user, err := findUser(ctx, us, args[0])
+	if err != nil {
+		return err
+	}
+	if err := us.VerifyEmail(ctx, user); err != nil {
+		return err
+	}
+	fmt.Printf("email verified for %s\n", user.Email)
+	return nil
+}
+
+func purgeDeleted(ctx context.Context, us *services.UserService, _ []string) error {
+	n, err := us.PurgeDeleted(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("purged %d deleted accounts\n", n)
+	return nil
+}
+
+// findUser looks an account up by email and fails when there is none.
+func findUser(ctx context.Context, us *services.UserService, email string) (*repository.User, error) {
+	email = utils.CleanString(email)
+	user, err := us.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("no user with email %q", email)
+	}
+	return user, nil
+}
+
+// readPassword prompts twice for a password on a terminal, or reads the
+// first line of stdin when it is redirected, for scripts.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", fmt.Errorf("read password: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	prompt := func(label string) (string, error) {
+		fmt.Fprint(os.Stderr, label)
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(b), err
+	}
+	password, err := prompt("Password: ")
+	if err != nil {
+		return "", err
+	}
+	repeat, err := prompt("Repeat password: ")
+	if err != nil {
+		return "", err
+	}
+	if password != repeat {
+		return "", errors.New("passwords do not match")
+	}
+	return password, nil
+}